@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Sanjar0126/go-simple-http/httpx"
@@ -28,83 +33,140 @@ func main() {
 		WriteTimeout:         30 * time.Second,
 	})
 
-	// Set up a simple handler
-	server.Handler = func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
-		fmt.Printf("Received %s request for %s\n", req.Method, req.Path)
+	router := httpx.NewRouter()
 
-		// Simple routing
-		switch req.Path {
-		case "/":
-			return &httpx.HTTPResponse{
-				StatusCode: 200,
-				StatusText: "OK",
-				Headers: map[string]string{
-					"Content-Type": "text/html",
-				},
-				Body: strings.NewReader("<h1>Hello, World!</h1><p>Keep-alive is working!</p>"),
-			}
-		case "/api/status":
-			return &httpx.HTTPResponse{
-				StatusCode: 200,
-				StatusText: "OK",
-				Headers: map[string]string{
-					"Content-Type": "application/json",
-				},
-				Body: strings.NewReader(`{"status": "OK", "keepalive": true}`),
+	router.Get("/", func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		return &httpx.HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    httpx.NewHeader("Content-Type", "text/html"),
+			Body:       strings.NewReader("<h1>Hello, World!</h1><p>Keep-alive is working!</p>"),
+		}
+	})
+
+	api := router.Group("/api")
+	api.Get("/status", func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		return &httpx.HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    httpx.NewHeader("Content-Type", "application/json"),
+			Body:       strings.NewReader(`{"status": "OK", "keepalive": true}`),
+		}
+	})
+	api.Get("/users/:id", func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		return &httpx.HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    httpx.NewHeader("Content-Type", "application/json"),
+			Body:       strings.NewReader(fmt.Sprintf(`{"id": %q}`, req.Param("id"))),
+		}
+	})
+
+	router.Get("/close", func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		// Force connection close
+		return &httpx.HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    httpx.NewHeader("Content-Type", "text/plain", "Connection", "close"),
+			Body:       strings.NewReader("Connection will be closed after this response"),
+		}
+	})
+
+	// uploaded files can be browsed and downloaded
+	router.Get("/files/*path", httpx.StripPrefix("/files",
+		httpx.FileServer(os.DirFS("."), httpx.FileServerConfig{Browse: true})))
+
+	router.Post("/upload", func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		// parts are streamed to disk one at a time instead of being buffered
+		reader, err := req.MultipartReader(httpx.MultipartConfig{MaxPartSize: 16 * 1024 * 1024})
+		if err != nil {
+			return plainResponse(http.StatusBadRequest, "Expected a multipart/form-data upload")
+		}
+
+		var saved []string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
 			}
-		case "/close":
-			// Force connection close
-			return &httpx.HTTPResponse{
-				StatusCode: 200,
-				StatusText: "OK",
-				Headers: map[string]string{
-					"Content-Type": "text/plain",
-					"Connection":   "close",
-				},
-				Body: strings.NewReader("Connection will be closed after this response"),
+			if err != nil {
+				fmt.Printf("Error reading upload: %v\n", err)
+				return uploadErrorResponse(err)
 			}
-		case "/upload":
-			safeName := strings.ReplaceAll(strings.Trim(req.Path, "/"), "/", "_")
-			if safeName == "" {
-				safeName = "root"
+			if part.FileName == "" {
+				continue
 			}
 
-			filename := fmt.Sprintf("%s_%d", safeName, time.Now().UnixNano())
+			filename := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(part.FileName))
 			file, err := os.Create(filename)
 			if err != nil {
 				fmt.Printf("Error creating file: %v\n", err)
+				return plainResponse(http.StatusInternalServerError, "Could not save upload")
 			}
-			defer file.Close()
 
-			written, err := io.Copy(file, req.Body)
+			written, err := io.Copy(file, part)
+			file.Close()
 			if err != nil {
 				fmt.Printf("Error writing to file: %v\n", err)
+				os.Remove(filename)
+				return uploadErrorResponse(err)
 			}
 
-			body := fmt.Sprintf("Saved %d bytes to %s", written, filename)
+			saved = append(saved, fmt.Sprintf("%s (%d bytes)", filename, written))
+		}
 
-			return &httpx.HTTPResponse{
-				StatusCode: http.StatusOK,
-				StatusText: http.StatusText(http.StatusOK),
-				Headers: map[string]string{
-					"content-Type": "text/plain",
-				},
-				Body: io.LimitReader(strings.NewReader(body), int64(len(body))),
-			}
-		default:
-			return &httpx.HTTPResponse{
-				StatusCode: 404,
-				StatusText: "Not Found",
-				Headers: map[string]string{
-					"Content-Type": "text/plain",
-				},
-				Body: strings.NewReader("Page not found"),
-			}
+		if len(saved) == 0 {
+			return plainResponse(http.StatusBadRequest, "No files in upload")
 		}
-	}
+		return plainResponse(http.StatusOK, "Saved "+strings.Join(saved, ", "))
+	}).MaxRequestSize(32 * 1024 * 1024)
+
+	// uploads larger than the route limit are refused with 413 before
+	// clients sending "Expect: 100-continue" transmit the body
+	server.RequestSizeLimit = router.RequestSizeLimit
+	handler := httpx.HandlerFunc(func(req *httpx.HTTPRequest) *httpx.HTTPResponse {
+		fmt.Printf("Received %s request for %s\n", req.Method, req.Path)
+		return router.ServeRequest(req)
+	})
+	server.StreamHandler = httpx.Chain(handler.Stream(),
+		httpx.Compression(httpx.CompressionConfig{}),
+		httpx.Conditional(httpx.ConditionalConfig{}),
+	)
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		fmt.Println("Shutting down HTTP server...")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Printf("Shutdown error: %v\n", err)
+			server.Close()
+		}
+	}()
 
 	fmt.Println("Starting HTTP server...")
-	if err := server.Start(); err != nil {
+	if err := server.Start(); err != nil && err != httpx.ErrServerClosed {
 		fmt.Printf("Server error: %v\n", err)
 	}
 }
+
+func plainResponse(statusCode int, text string) *httpx.HTTPResponse {
+	return &httpx.HTTPResponse{
+		StatusCode: statusCode,
+		StatusText: http.StatusText(statusCode),
+		Headers:    httpx.NewHeader("Content-Type", "text/plain"),
+		Body:       strings.NewReader(text),
+	}
+}
+
+func uploadErrorResponse(err error) *httpx.HTTPResponse {
+	var maxBytes *httpx.MaxBytesError
+	if errors.Is(err, httpx.ErrPartTooLarge) || errors.As(err, &maxBytes) {
+		return plainResponse(http.StatusRequestEntityTooLarge, "Upload too large")
+	}
+	return plainResponse(http.StatusBadRequest, "Malformed upload")
+}
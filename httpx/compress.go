@@ -0,0 +1,433 @@
+package httpx
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder wraps w so that everything written to the returned writer is
+// compressed into w. Closing it must write any remaining data but not close
+// w. Writers that also have a Flush() error method are flushed whenever the
+// handler flushes the response.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+var (
+	encodersMu     sync.RWMutex
+	encoders       = map[string]Encoder{}
+	encoderOrder   []string
+	gzipWriters    sync.Pool
+	deflateWriters sync.Pool
+)
+
+func init() {
+	RegisterEncoder("gzip", newGzipEncoder)
+	RegisterEncoder("deflate", newDeflateEncoder)
+}
+
+// RegisterEncoder makes a content-coding available to Compression under name,
+// as used in Accept-Encoding and Content-Encoding. Registering a name again
+// replaces its encoder.
+func RegisterEncoder(name string, encoder Encoder) {
+	name = strings.ToLower(name)
+
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	if _, exists := encoders[name]; !exists {
+		encoderOrder = append(encoderOrder, name)
+	}
+	encoders[name] = encoder
+}
+
+func lookupEncoder(name string) Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return encoders[name]
+}
+
+func registeredEncodings() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	return append([]string(nil), encoderOrder...)
+}
+
+// pooledWriter returns a default level compressor to its pool on Close.
+type pooledWriter struct {
+	io.WriteCloser
+	flush func() error
+	pool  *sync.Pool
+}
+
+func (p *pooledWriter) Flush() error {
+	return p.flush()
+}
+
+func (p *pooledWriter) Close() error {
+	err := p.WriteCloser.Close()
+	p.pool.Put(p.WriteCloser)
+	return err
+}
+
+func newGzipEncoder(w io.Writer, level int) (io.WriteCloser, error) {
+	if level != gzip.DefaultCompression {
+		return gzip.NewWriterLevel(w, level)
+	}
+
+	if v := gzipWriters.Get(); v != nil {
+		gz := v.(*gzip.Writer)
+		gz.Reset(w)
+		return &pooledWriter{WriteCloser: gz, flush: gz.Flush, pool: &gzipWriters}, nil
+	}
+	gz := gzip.NewWriter(w)
+	return &pooledWriter{WriteCloser: gz, flush: gz.Flush, pool: &gzipWriters}, nil
+}
+
+// newDeflateEncoder writes the zlib format, which is what the "deflate"
+// coding means in HTTP (RFC 9110 8.4.1.2).
+func newDeflateEncoder(w io.Writer, level int) (io.WriteCloser, error) {
+	if level != zlib.DefaultCompression {
+		return zlib.NewWriterLevel(w, level)
+	}
+
+	if v := deflateWriters.Get(); v != nil {
+		zw := v.(*zlib.Writer)
+		zw.Reset(w)
+		return &pooledWriter{WriteCloser: zw, flush: zw.Flush, pool: &deflateWriters}, nil
+	}
+	zw := zlib.NewWriter(w)
+	return &pooledWriter{WriteCloser: zw, flush: zw.Flush, pool: &deflateWriters}, nil
+}
+
+// DefaultCompressibleTypes are the media types Compression compresses when
+// CompressionConfig.ContentTypes is empty. A trailing "/*" matches a whole
+// top-level type.
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+type CompressionConfig struct {
+	// MinSize is the smallest body, in bytes, worth compressing. Defaults to
+	// DefaultCompressionMinSize.
+	MinSize int
+
+	// Level is passed to the encoder. 0 uses each encoder's default level.
+	Level int
+
+	// ContentTypes lists the media types to compress. Defaults to
+	// DefaultCompressibleTypes.
+	ContentTypes []string
+
+	// Encodings lists the codings to offer, in order of preference when the
+	// client rates several equally. Defaults to every registered encoder.
+	Encodings []string
+}
+
+// Compression returns a Middleware that compresses response bodies with the
+// best coding the client accepts. HEAD responses, responses without a body,
+// and those already encoded, partial (206 or Content-Range), marked
+// Cache-Control: no-transform, smaller than MinSize or of a type not listed in
+// ContentTypes are sent as they are.
+func Compression(cfg CompressionConfig) Middleware {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = DefaultCompressionMinSize
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	if cfg.ContentTypes == nil {
+		cfg.ContentTypes = DefaultCompressibleTypes
+	}
+
+	return func(next StreamHandlerFunc) StreamHandlerFunc {
+		return func(w ResponseWriter, req *HTTPRequest) {
+			encodings := cfg.Encodings
+			if encodings == nil {
+				encodings = registeredEncodings()
+			}
+
+			// a HEAD response has no body to encode, so it keeps the
+			// handler's Content-Length instead of the encoded framing
+			encoding := ""
+			if req.Method != http.MethodHead {
+				encoding = negotiateEncoding(req.Headers.Values(AcceptEncodingHeader), encodings)
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				encoding:       encoding,
+				statusCode:     http.StatusOK,
+			}
+
+			next(cw, req)
+
+			if err := cw.close(); err != nil {
+				fmt.Printf("Error compressing response: %v\n", err)
+			}
+		}
+	}
+}
+
+// negotiateEncoding picks the coding with the highest q-value among the
+// offered ones (RFC 9110 12.5.3). It returns "" when the body should be sent
+// unencoded.
+func negotiateEncoding(acceptEncoding []string, offered []string) string {
+	if len(acceptEncoding) == 0 {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, value := range acceptEncoding {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+					if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+						q = parsed
+					}
+				}
+			}
+
+			if name == "*" {
+				wildcard = q
+			} else {
+				weights[name] = q
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range offered {
+		q, ok := weights[name]
+		if !ok {
+			q = wildcard
+		}
+		// earlier entries in offered win ties
+		if q > bestQ && lookupEncoder(name) != nil {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter holds back the first MinSize bytes of the body to decide
+// whether compressing is worth it, then either streams through the encoder
+// or passes everything on unchanged.
+type compressWriter struct {
+	ResponseWriter
+	cfg      *CompressionConfig
+	encoding string
+
+	statusCode int
+	buf        []byte
+	decided    bool
+	encoder    io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(statusCode int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	if isInterimStatus(statusCode) {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	cw.statusCode = statusCode
+	if !bodyAllowedForStatus(statusCode) {
+		cw.decide(false, nil)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	if len(cw.buf)+len(p) < cw.cfg.MinSize {
+		cw.buf = append(cw.buf, p...)
+		return len(p), nil
+	}
+
+	if err := cw.decide(true, p); err != nil {
+		return 0, err
+	}
+	return cw.Write(p)
+}
+
+// Flush sends what has been written so far, compressed if the response is
+// eligible, since a flushing handler is streaming a body of unknown size.
+func (cw *compressWriter) Flush() error {
+	if !cw.decided {
+		if err := cw.decide(true, nil); err != nil {
+			return err
+		}
+	}
+
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		if err := flusher.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return cw.ResponseWriter.Flush()
+}
+
+// close runs after the handler has returned and finishes the encoded body.
+func (cw *compressWriter) close() error {
+	if !cw.decided {
+		if err := cw.decide(len(cw.buf) >= cw.cfg.MinSize, nil); err != nil {
+			return err
+		}
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// decide sends the header, with Content-Encoding if the response is going to
+// be compressed, followed by the body buffered so far. next is the write that
+// triggered the decision, used to sniff the content type if nothing was
+// buffered.
+func (cw *compressWriter) decide(bigEnough bool, next []byte) error {
+	cw.decided = true
+	header := cw.Header()
+
+	sample := cw.buf
+	if len(sample) == 0 {
+		sample = next
+	}
+
+	if cw.varies(sample) {
+		if !headerContainsToken(header.Values(VaryHeader), AcceptEncodingHeader) {
+			header.Add(VaryHeader, "Accept-Encoding")
+		}
+
+		if length := header.Get(ContentLengthHeader); length != "" {
+			if n, err := strconv.Atoi(length); err == nil && n < cw.cfg.MinSize {
+				bigEnough = false
+			}
+		}
+
+		if cw.encoding != "" && bigEnough {
+			encoder, err := lookupEncoder(cw.encoding)(cw.ResponseWriter, cw.cfg.Level)
+			if err != nil {
+				cw.ResponseWriter.WriteHeader(cw.statusCode)
+				return fmt.Errorf("error creating %s encoder: %v", cw.encoding, err)
+			}
+			cw.encoder = encoder
+
+			header.Set(ContentEncodingHeader, cw.encoding)
+			header.Del(ContentLengthHeader)
+
+			// the encoded representation is a different one (RFC 9110 8.8.3)
+			if etag := header.Get(ETagHeader); etag != "" {
+				header.Set(ETagHeader, WeakETag(etag))
+			}
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.encoder != nil {
+		_, err := cw.encoder.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// varies reports whether the response could be compressed at all, and so
+// depends on Accept-Encoding.
+func (cw *compressWriter) varies(sample []byte) bool {
+	header := cw.Header()
+
+	if !bodyAllowedForStatus(cw.statusCode) || cw.statusCode == http.StatusPartialContent {
+		return false
+	}
+	if header.Has(ContentEncodingHeader) || header.Has(ContentRangeHeader) {
+		return false
+	}
+	if headerContainsToken(header.Values(CacheControlHeader), "no-transform") {
+		return false
+	}
+
+	contentType := header.Get(ContentTypeHeader)
+	if contentType == "" {
+		contentType = http.DetectContentType(sample)
+	}
+
+	return compressibleType(contentType, cw.cfg.ContentTypes)
+}
+
+func compressibleType(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerContainsToken reports whether token appears in any of the
+// comma-separated values.
+func headerContainsToken(values []string, token string) bool {
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item, _, _ = strings.Cut(item, "=")
+			if strings.EqualFold(strings.TrimSpace(item), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
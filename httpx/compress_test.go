@@ -0,0 +1,255 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupCompressionServer(t *testing.T, cfg CompressionConfig, handler StreamHandlerFunc) (string, func()) {
+	_, addr, cleanup := setupStreamTestServer(t, Chain(handler, Compression(cfg)))
+	return addr, cleanup
+}
+
+func getWithEncoding(t *testing.T, addr, method, acceptEncoding string) (*http.Response, []byte) {
+	conn := makeRawConnection(t, addr)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	request := method + " / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"
+	if acceptEncoding != "" {
+		request += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	conn.Write([]byte(request + "\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: method})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+
+	return res, body
+}
+
+func textHandler(contentType, body string) StreamHandlerFunc {
+	return HandlerFunc(func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			Headers:    NewHeader(ContentTypeHeader, contentType, ETagHeader, `"v1"`),
+			Body:       strings.NewReader(body),
+		}
+	}).Stream()
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	offered := []string{"gzip", "deflate"}
+
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip; q=0, deflate;q=0.1", "deflate"},
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0", "deflate"},
+		{"identity", ""},
+		{"br", ""},
+		{"GZIP;Q=1.0", "gzip"},
+		{"gzip;q=0, *;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		var header []string
+		if tt.acceptEncoding != "" {
+			header = []string{tt.acceptEncoding}
+		}
+		if got := negotiateEncoding(header, offered); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompressionGzip(t *testing.T) {
+	content := strings.Repeat("compress me please ", 200)
+	addr, cleanup := setupCompressionServer(t, CompressionConfig{}, textHandler("text/plain; charset=utf-8", content))
+	defer cleanup()
+
+	res, body := getWithEncoding(t, addr, "GET", "deflate;q=0.5, gzip")
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got: %v", res.Header)
+	}
+	if res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got: %v", res.Header)
+	}
+	if res.Header.Get("Etag") != `W/"v1"` {
+		t.Errorf("Expected weakened ETag, got: %q", res.Header.Get("Etag"))
+	}
+	if res.ContentLength != -1 || len(body) >= len(content) {
+		t.Errorf("Expected streamed, smaller body, got length %d of %d", len(body), len(content))
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Invalid gzip body: %v", err)
+	}
+	decoded, _ := io.ReadAll(reader)
+	if string(decoded) != content {
+		t.Errorf("Decoded body does not match")
+	}
+}
+
+func TestCompressionDeflateStreaming(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "application/json")
+		for i := 0; i < 3; i++ {
+			w.Write([]byte(`{"event": "tick"}` + "\n"))
+			w.Flush()
+		}
+	}
+
+	addr, cleanup := setupCompressionServer(t, CompressionConfig{}, handler)
+	defer cleanup()
+
+	res, body := getWithEncoding(t, addr, "GET", "deflate")
+
+	if res.Header.Get("Content-Encoding") != "deflate" {
+		t.Fatalf("Expected deflate encoding for flushed stream, got: %v", res.Header)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Invalid zlib header: %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Invalid deflate body: %v", err)
+	}
+	if string(decoded) != strings.Repeat(`{"event": "tick"}`+"\n", 3) {
+		t.Errorf("Unexpected decoded body: %q", decoded)
+	}
+}
+
+func TestCompressionSkipped(t *testing.T) {
+	large := strings.Repeat("a", 4096)
+
+	tests := []struct {
+		name     string
+		handler  StreamHandlerFunc
+		encoding string
+		vary     bool
+	}{
+		{"client without Accept-Encoding", textHandler("text/plain", large), "", true},
+		{"below min size", textHandler("text/plain", "tiny"), "gzip", true},
+		{"incompressible type", textHandler("image/png", large), "gzip", false},
+		{"already encoded", func(w ResponseWriter, req *HTTPRequest) {
+			w.Header().Set(ContentEncodingHeader, "br")
+			w.Write([]byte(large))
+		}, "gzip", false},
+		{"partial content", func(w ResponseWriter, req *HTTPRequest) {
+			w.Header().Set(ContentTypeHeader, "text/plain")
+			w.Header().Set(ContentRangeHeader, "bytes 0-4095/10000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(large))
+		}, "gzip", false},
+		{"no-transform", func(w ResponseWriter, req *HTTPRequest) {
+			w.Header().Set(ContentTypeHeader, "text/plain")
+			w.Header().Set(CacheControlHeader, "public, no-transform")
+			w.Write([]byte(large))
+		}, "gzip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, cleanup := setupCompressionServer(t, CompressionConfig{}, tt.handler)
+			defer cleanup()
+
+			res, body := getWithEncoding(t, addr, "GET", tt.encoding)
+
+			if encoding := res.Header.Get("Content-Encoding"); encoding == "gzip" {
+				t.Errorf("Expected body not to be compressed")
+			}
+			if vary := res.Header.Get("Vary") != ""; vary != tt.vary {
+				t.Errorf("Expected Vary present = %v, got: %v", tt.vary, res.Header)
+			}
+			if len(body) != len(large) && tt.name != "below min size" {
+				t.Errorf("Expected unchanged body, got %d bytes", len(body))
+			}
+		})
+	}
+}
+
+func TestCompressionHead(t *testing.T) {
+	content := strings.Repeat("head ", 1000)
+	addr, cleanup := setupCompressionServer(t, CompressionConfig{}, textHandler("text/html", content))
+	defer cleanup()
+
+	res, body := getWithEncoding(t, addr, "HEAD", "gzip")
+
+	if res.Header.Get("Content-Encoding") != "" || res.ContentLength != int64(len(content)) || res.TransferEncoding != nil {
+		t.Errorf("Expected HEAD to keep the handler's length uncompressed, got: %v", res.Header)
+	}
+	if res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected Vary: Accept-Encoding, got: %v", res.Header)
+	}
+	if len(body) != 0 {
+		t.Errorf("Expected no body for HEAD, got %d bytes", len(body))
+	}
+}
+
+func TestCompressionBodylessStatus(t *testing.T) {
+	addr, cleanup := setupCompressionServer(t, CompressionConfig{}, func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	defer cleanup()
+
+	res, _ := getWithEncoding(t, addr, "GET", "gzip")
+
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Content-Encoding") != "" || res.TransferEncoding != nil {
+		t.Errorf("Expected 204 without encoding or framing, got %d %v", res.StatusCode, res.Header)
+	}
+}
+
+type upperEncoder struct {
+	w io.Writer
+}
+
+func (u upperEncoder) Write(p []byte) (int, error) {
+	return u.w.Write(bytes.ToUpper(p))
+}
+
+func (u upperEncoder) Close() error {
+	return nil
+}
+
+func TestCompressionCustomEncoder(t *testing.T) {
+	RegisterEncoder("x-upper", func(w io.Writer, level int) (io.WriteCloser, error) {
+		return upperEncoder{w}, nil
+	})
+
+	cfg := CompressionConfig{MinSize: 1, Encodings: []string{"x-upper", "gzip"}}
+	addr, cleanup := setupCompressionServer(t, cfg, textHandler("text/plain", "shout"))
+	defer cleanup()
+
+	res, body := getWithEncoding(t, addr, "GET", "gzip, x-upper")
+
+	if res.Header.Get("Content-Encoding") != "x-upper" || string(body) != "SHOUT" {
+		t.Errorf("Expected custom encoder to be preferred, got %v %q", res.Header, body)
+	}
+}
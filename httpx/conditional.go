@@ -0,0 +1,362 @@
+package httpx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileETag returns a strong entity tag derived from the size and modification
+// time of a file, so the content doesn't have to be read. It returns "" for
+// files without a modification time, such as those of an embed.FS.
+func FileETag(info fs.FileInfo) string {
+	if info.ModTime().IsZero() {
+		return ""
+	}
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+// HashETag returns a strong entity tag derived from the content itself.
+func HashETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag marks etag as weak, for representations that are equivalent to
+// the tagged one but not byte for byte identical.
+func WeakETag(etag string) string {
+	if !strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return "W/" + etag
+}
+
+// CheckPreconditions evaluates the If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since headers of req against the current
+// validators of the target resource, in the order of RFC 9110 13.2.2. etag
+// is "" and lastModified zero when the resource doesn't have them.
+//
+// It returns 0 when the request should proceed, otherwise
+// http.StatusNotModified or http.StatusPreconditionFailed. Handlers of
+// state-changing methods call it before acting on the resource.
+func CheckPreconditions(req *HTTPRequest, etag string, lastModified time.Time) int {
+	// HTTP dates have a resolution of one second
+	lastModified = lastModified.Truncate(time.Second)
+
+	if values := req.Headers.Values(IfMatchHeader); len(values) > 0 {
+		if !etagListMatches(values, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, IfUnmodifiedSinceHeader); ok && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safe := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	if values := req.Headers.Values(IfNoneMatchHeader); len(values) > 0 {
+		if etagListMatches(values, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, ok := headerTime(req, IfModifiedSinceHeader); ok && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+func headerTime(req *HTTPRequest, name string) (time.Time, bool) {
+	value := req.Headers.Get(name)
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+// etagListMatches reports whether the comma-separated entity tags of values
+// match etag, using the strong or weak comparison of RFC 9110 8.8.3.2. "*"
+// matches any current representation.
+func etagListMatches(values []string, etag string, strong bool) bool {
+	for _, value := range values {
+		for {
+			value = strings.TrimLeft(value, " \t,")
+			if value == "" {
+				break
+			}
+			if value[0] == '*' {
+				return true
+			}
+
+			tag, rest, ok := nextETag(value)
+			if !ok {
+				break
+			}
+			value = rest
+
+			if etag != "" && etagsMatch(tag, etag, strong) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextETag splits the entity tag at the start of s from the rest. Tags are
+// scanned rather than split on commas, as a comma is valid within one.
+func nextETag(s string) (string, string, bool) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s) <= start || s[start] != '"' {
+		return "", "", false
+	}
+
+	end := strings.IndexByte(s[start+1:], '"')
+	if end < 0 {
+		return "", "", false
+	}
+	end += start + 2
+
+	return s[:end], s[end:], true
+}
+
+func etagsMatch(a, b string, strong bool) bool {
+	if strong {
+		return !strings.HasPrefix(a, "W/") && !strings.HasPrefix(b, "W/") && a == b
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// stripRepresentation removes the fields describing a body from the header
+// of a 304 or 412 response, which doesn't carry one.
+func stripRepresentation(header *Header, statusCode int) {
+	for _, name := range []string{ContentTypeHeader, ContentLengthHeader, ContentEncodingHeader,
+		ContentRangeHeader, AcceptRangesHeader, TransferEncodingHeader, TrailerHeader} {
+		header.Del(name)
+	}
+
+	// the ETag alone is enough to update a cached response
+	if statusCode == http.StatusNotModified && header.Has(ETagHeader) {
+		header.Del(LastModifiedHeader)
+	}
+}
+
+// preconditionResponse answers req with a 304 or 412 response when its
+// preconditions fail for the validators in res, closing a body the package
+// opened. Otherwise res is returned.
+func preconditionResponse(req *HTTPRequest, res *HTTPResponse) *HTTPResponse {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res
+	}
+
+	modified, _ := http.ParseTime(res.Headers.Get(LastModifiedHeader))
+	statusCode := CheckPreconditions(req, res.Headers.Get(ETagHeader), modified)
+	if statusCode == 0 {
+		return res
+	}
+
+	if closer, ok := res.Body.(io.Closer); ok && res.closeBody {
+		closer.Close()
+	}
+
+	headers := res.Headers
+	stripRepresentation(&headers, statusCode)
+
+	return &HTTPResponse{StatusCode: statusCode, Headers: headers}
+}
+
+type ConditionalConfig struct {
+	// HashLimit is the largest body, in bytes, buffered to compute an ETag
+	// with HashETag for 200 responses that don't set one. Defaults to
+	// DefaultETagHashLimit; a negative value disables hashing.
+	HashLimit int
+}
+
+// Conditional answers conditional GET and HEAD requests with 304 Not
+// Modified or 412 Precondition Failed, based on the ETag and Last-Modified
+// headers of the handler's response, and drops the body. Other methods pass
+// through, as the handler has already acted by the time its response is
+// seen; it should call CheckPreconditions itself.
+func Conditional(cfg ConditionalConfig) Middleware {
+	if cfg.HashLimit == 0 {
+		cfg.HashLimit = DefaultETagHashLimit
+	}
+
+	return func(next StreamHandlerFunc) StreamHandlerFunc {
+		return func(w ResponseWriter, req *HTTPRequest) {
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				next(w, req)
+				return
+			}
+
+			cw := &conditionalWriter{
+				ResponseWriter: w,
+				req:            req,
+				hashLimit:      cfg.HashLimit,
+			}
+
+			next(cw, req)
+
+			if err := cw.close(); err != nil {
+				fmt.Printf("Error writing conditional response: %v\n", err)
+			}
+		}
+	}
+}
+
+type conditionalWriter struct {
+	ResponseWriter
+	req       *HTTPRequest
+	hashLimit int
+
+	statusCode int
+	buf        []byte
+	hashing    bool
+	decided    bool
+	discard    bool
+}
+
+func (cw *conditionalWriter) WriteHeader(statusCode int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if cw.hashing {
+		// the status is already committed
+		return
+	}
+
+	if isInterimStatus(statusCode) {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	cw.statusCode = statusCode
+	if cw.hashable() {
+		cw.hashing = true
+		return
+	}
+	cw.decide()
+}
+
+// hashable reports whether the body has to be buffered to compute an ETag.
+func (cw *conditionalWriter) hashable() bool {
+	header := cw.Header()
+	if cw.statusCode != http.StatusOK || cw.hashLimit < 0 || header.Has(ETagHeader) {
+		return false
+	}
+
+	if length := header.Get(ContentLengthHeader); length != "" {
+		n, err := strconv.ParseInt(length, 10, 64)
+		return err == nil && n <= int64(cw.hashLimit)
+	}
+
+	// trailers are sent once the body is done, which buffering would
+	// postpone for no benefit
+	return !header.Has(TrailerHeader)
+}
+
+func (cw *conditionalWriter) Write(p []byte) (int, error) {
+	if !cw.decided && !cw.hashing {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.hashing {
+		if len(cw.buf)+len(p) <= cw.hashLimit {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+
+		// too large to hash, send it with the validators it has
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+
+	if cw.discard {
+		return len(p), nil
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush gives up on hashing, since a flushing handler is streaming.
+func (cw *conditionalWriter) Flush() error {
+	if !cw.decided {
+		if !cw.hashing {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+
+	if cw.discard {
+		return nil
+	}
+	return cw.ResponseWriter.Flush()
+}
+
+// close runs after the handler has returned and tags a buffered body.
+func (cw *conditionalWriter) close() error {
+	if cw.decided {
+		return nil
+	}
+	if !cw.hashing {
+		cw.WriteHeader(http.StatusOK)
+		if cw.decided {
+			return nil
+		}
+	}
+
+	cw.Header().Set(ETagHeader, HashETag(cw.buf))
+	return cw.decide()
+}
+
+// decide evaluates the preconditions and sends either the 304 or 412
+// response, or the handler's header followed by the body buffered so far.
+func (cw *conditionalWriter) decide() error {
+	cw.decided = true
+	cw.hashing = false
+	header := cw.Header()
+
+	statusCode := 0
+	if cw.statusCode >= 200 && cw.statusCode <= 299 {
+		modified, _ := http.ParseTime(header.Get(LastModifiedHeader))
+		statusCode = CheckPreconditions(cw.req, header.Get(ETagHeader), modified)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if statusCode != 0 {
+		cw.discard = true
+		stripRepresentation(header, statusCode)
+		if statusCode != http.StatusNotModified {
+			header.Set(ContentLengthHeader, "0")
+		}
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return nil
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.statusCode)
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
@@ -0,0 +1,237 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 500, time.UTC)
+	before := "Tue, 20 Oct 2015 07:28:00 GMT"
+	same := "Wed, 21 Oct 2015 07:28:00 GMT"
+
+	tests := []struct {
+		method  string
+		headers []string
+		want    int
+	}{
+		{"GET", nil, 0},
+		{"GET", []string{IfNoneMatchHeader, `"v1"`}, http.StatusNotModified},
+		{"HEAD", []string{IfNoneMatchHeader, `W/"v1"`}, http.StatusNotModified},
+		{"GET", []string{IfNoneMatchHeader, `"v0", "v1"`}, http.StatusNotModified},
+		{"GET", []string{IfNoneMatchHeader, `"a,b", "v1"`}, http.StatusNotModified},
+		{"GET", []string{IfNoneMatchHeader, `"v2"`}, 0},
+		{"GET", []string{IfNoneMatchHeader, "*"}, http.StatusNotModified},
+		{"PUT", []string{IfNoneMatchHeader, "*"}, http.StatusPreconditionFailed},
+		{"GET", []string{IfMatchHeader, `"v1"`}, 0},
+		{"GET", []string{IfMatchHeader, `W/"v1"`}, http.StatusPreconditionFailed},
+		{"DELETE", []string{IfMatchHeader, `"v2"`}, http.StatusPreconditionFailed},
+		{"GET", []string{IfModifiedSinceHeader, same}, http.StatusNotModified},
+		{"GET", []string{IfModifiedSinceHeader, before}, 0},
+		{"GET", []string{IfModifiedSinceHeader, "not a date"}, 0},
+		{"POST", []string{IfModifiedSinceHeader, same}, 0},
+		{"GET", []string{IfUnmodifiedSinceHeader, same}, 0},
+		{"PUT", []string{IfUnmodifiedSinceHeader, before}, http.StatusPreconditionFailed},
+
+		// If-None-Match takes precedence over If-Modified-Since
+		{"GET", []string{IfNoneMatchHeader, `"v2"`, IfModifiedSinceHeader, same}, 0},
+		// and If-Match over If-Unmodified-Since
+		{"GET", []string{IfMatchHeader, `"v1"`, IfUnmodifiedSinceHeader, before}, 0},
+		// a failed If-Match wins over a matching If-None-Match
+		{"GET", []string{IfMatchHeader, `"v2"`, IfNoneMatchHeader, `"v1"`}, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		req := &HTTPRequest{Method: tt.method, Headers: NewHeader(tt.headers...)}
+		if got := CheckPreconditions(req, `"v1"`, modified); got != tt.want {
+			t.Errorf("%s %v: got %d, want %d", tt.method, tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPreconditionsWithoutValidators(t *testing.T) {
+	req := &HTTPRequest{Method: "GET", Headers: NewHeader(IfNoneMatchHeader, `"v1"`, IfModifiedSinceHeader, "Wed, 21 Oct 2015 07:28:00 GMT")}
+	if got := CheckPreconditions(req, "", time.Time{}); got != 0 {
+		t.Errorf("Expected request to proceed, got %d", got)
+	}
+
+	req = &HTTPRequest{Method: "GET", Headers: NewHeader(IfMatchHeader, `"v1"`)}
+	if got := CheckPreconditions(req, "", time.Time{}); got != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", got)
+	}
+}
+
+func TestETagHelpers(t *testing.T) {
+	if a, b := HashETag([]byte("a")), HashETag([]byte("b")); a == b || !strings.HasPrefix(a, `"`) {
+		t.Errorf("Expected distinct strong tags, got %s and %s", a, b)
+	}
+	if got := WeakETag(`"v1"`); got != `W/"v1"` {
+		t.Errorf("Expected weak tag, got %s", got)
+	}
+	if got := WeakETag(`W/"v1"`); got != `W/"v1"` {
+		t.Errorf("Expected weak tag to be kept, got %s", got)
+	}
+
+	info := &fstest.MapFile{Data: []byte("abc"), ModTime: time.Unix(1445412480, 0)}
+	fsys := fstest.MapFS{"a.txt": info, "b.txt": {Data: []byte("abc")}}
+	stat, _ := fsys.Stat("a.txt")
+	if got := FileETag(stat); got != `"140f22fe10510000-3"` {
+		t.Errorf("Unexpected file tag %s", got)
+	}
+	stat, _ = fsys.Stat("b.txt")
+	if got := FileETag(stat); got != "" {
+		t.Errorf("Expected no tag without a modification time, got %s", got)
+	}
+}
+
+func setupConditionalServer(t *testing.T, cfg ConditionalConfig, handler HandlerFunc) (string, func()) {
+	_, addr, cleanup := setupStreamTestServer(t, Chain(handler.Stream(), Conditional(cfg)))
+	return addr, cleanup
+}
+
+func getConditional(t *testing.T, addr string, headers ...string) (*http.Response, []byte) {
+	conn := makeRawConnection(t, addr)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	request := "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"
+	for i := 0; i+1 < len(headers); i += 2 {
+		request += headers[i] + ": " + headers[i+1] + "\r\n"
+	}
+	conn.Write([]byte(request + "\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+
+	return res, body
+}
+
+func TestConditionalHashesBody(t *testing.T) {
+	body := "<h1>hello</h1>"
+	addr, cleanup := setupConditionalServer(t, ConditionalConfig{}, func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Headers: NewHeader(ContentTypeHeader, "text/html"), Body: strings.NewReader(body)}
+	})
+	defer cleanup()
+
+	res, data := getConditional(t, addr)
+	etag := res.Header.Get("ETag")
+	if res.StatusCode != 200 || string(data) != body || etag != HashETag([]byte(body)) {
+		t.Fatalf("Expected tagged 200 response, got %d %q with ETag %q", res.StatusCode, data, etag)
+	}
+
+	res, data = getConditional(t, addr, "If-None-Match", etag)
+	if res.StatusCode != http.StatusNotModified || len(data) != 0 {
+		t.Errorf("Expected 304 without body, got %d %q", res.StatusCode, data)
+	}
+	if res.Header.Get("ETag") != etag || res.Header.Get("Content-Type") != "" {
+		t.Errorf("Unexpected 304 headers: %v", res.Header)
+	}
+
+	res, data = getConditional(t, addr, "If-Match", `"other"`)
+	if res.StatusCode != http.StatusPreconditionFailed || len(data) != 0 || res.ContentLength != 0 {
+		t.Errorf("Expected empty 412, got %d %q", res.StatusCode, data)
+	}
+}
+
+func TestConditionalLastModified(t *testing.T) {
+	modified := "Wed, 21 Oct 2015 07:28:00 GMT"
+	addr, cleanup := setupConditionalServer(t, ConditionalConfig{HashLimit: -1}, func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Headers: NewHeader(LastModifiedHeader, modified), Body: strings.NewReader("content")}
+	})
+	defer cleanup()
+
+	res, _ := getConditional(t, addr)
+	if res.Header.Get("ETag") != "" {
+		t.Errorf("Expected no ETag with hashing disabled, got %q", res.Header.Get("ETag"))
+	}
+
+	res, data := getConditional(t, addr, "If-Modified-Since", modified)
+	if res.StatusCode != http.StatusNotModified || len(data) != 0 {
+		t.Errorf("Expected 304, got %d %q", res.StatusCode, data)
+	}
+	if res.Header.Get("Last-Modified") != modified {
+		t.Errorf("Expected Last-Modified on 304 without ETag, got %q", res.Header.Get("Last-Modified"))
+	}
+
+	res, data = getConditional(t, addr, "If-Modified-Since", "Tue, 20 Oct 2015 07:28:00 GMT")
+	if res.StatusCode != 200 || string(data) != "content" {
+		t.Errorf("Expected full response, got %d %q", res.StatusCode, data)
+	}
+}
+
+func TestConditionalSkipsLargeAndStreamedBodies(t *testing.T) {
+	large := strings.Repeat("x", 100)
+	_, addr, cleanup := setupStreamTestServer(t, Chain(func(w ResponseWriter, req *HTTPRequest) {
+		if req.Path == "/stream" {
+			w.Write([]byte("part"))
+			w.Flush()
+			w.Write([]byte("part"))
+			return
+		}
+		w.Write([]byte(large))
+	}, Conditional(ConditionalConfig{HashLimit: 50})))
+	defer cleanup()
+
+	for _, path := range []string{"/", "/stream"} {
+		response := makeRequest(t, addr, "GET "+path+" HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+		if !strings.HasPrefix(response, "HTTP/1.1 200") || strings.Contains(strings.ToLower(response), "etag") {
+			t.Errorf("%s: expected untagged 200 response, got %q", path, response)
+		}
+	}
+}
+
+func TestConditionalIgnoresUnsafeMethods(t *testing.T) {
+	addr, cleanup := setupConditionalServer(t, ConditionalConfig{}, func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Headers: NewHeader(ETagHeader, `"v1"`), Body: strings.NewReader("done")}
+	})
+	defer cleanup()
+
+	response := makeRequest(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"v1\"\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(response, "HTTP/1.1 200") {
+		t.Errorf("Expected POST to pass through, got %q", response)
+	}
+}
+
+func TestFileServerConditional(t *testing.T) {
+	modified := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	handler := FileServer(fstest.MapFS{
+		"a.txt": {Data: []byte("hello"), ModTime: modified},
+	}, FileServerConfig{})
+
+	res, body := serveFile(t, handler, "GET", "/a.txt")
+	etag := res.Headers.Get(ETagHeader)
+	if res.StatusCode != 200 || body != "hello" || etag == "" {
+		t.Fatalf("Expected tagged file, got %d %q %q", res.StatusCode, body, etag)
+	}
+	if got := res.Headers.Get(LastModifiedHeader); got != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Unexpected Last-Modified %q", got)
+	}
+
+	res, _ = serveFile(t, handler, "GET", "/a.txt", IfNoneMatchHeader, etag)
+	if res.StatusCode != http.StatusNotModified || res.Body != nil || res.Headers.Has(ContentTypeHeader) {
+		t.Errorf("Expected bodyless 304, got %d %v", res.StatusCode, res.Headers)
+	}
+
+	res, _ = serveFile(t, handler, "HEAD", "/a.txt", IfModifiedSinceHeader, "Wed, 21 Oct 2015 07:28:00 GMT")
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", res.StatusCode)
+	}
+
+	res, _ = serveFile(t, handler, "GET", "/a.txt", IfMatchHeader, `"stale"`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412, got %d", res.StatusCode)
+	}
+}
@@ -9,21 +9,68 @@ const (
 
 	DefaultMaxRequestSize = 1024 * 1024 // 1MB
 	DefaultMaxHeaderSize  = 8192        // 8KB
+	DefaultMaxDrainSize   = 256 * 1024  // 256KB
 
 	DefaultKeepAliveTimeout     = 60 * time.Second
 	DefaultMaxKeepAliveRequests = 100
 
 	DefaultChunkSize = 8192
 
-	ContentTypeHeader      = "content-type"
-	ContentLengthHeader    = "content-length"
-	ConnectionHeader       = "connection"
-	TransferEncodingHeader = "transfer-encoding"
-	KeepAliveHeader        = "keep-alive"
-	CloseHeader            = "close"
-	UpgradeHeader          = "upgrade"
-	AcceptEncodingHeader   = "accept-encoding"
-	AcceptLanguageHeader   = "accept-language"
-	AcceptHeader           = "accept"
-	CacheControlHeader     = "cache-control"
+	DefaultCompressionMinSize = 1024 // 1KB
+
+	DefaultETagHashLimit = 64 * 1024 // 64KB
+
+	DefaultMultipartMemory   = 1024 * 1024 // 1MB
+	DefaultMultipartMaxParts = 1000
+
+	DefaultSessionCookieName = "session"
+	DefaultSessionMaxAge     = 24 * time.Hour
+
+	maxCookieSize        = 4096
+	sessionKeySize       = 32
+	sessionSweepInterval = time.Minute
+
+	maxChunkLineSize = 4096
+	writeBufferSize  = 4096
+
+	shutdownPollInterval = 50 * time.Millisecond
+
+	UnixAddrPrefix = "unix:"
+
+	listenFDsStart = 3 // SD_LISTEN_FDS_START
+
+	ContentTypeHeader       = "content-type"
+	ContentLengthHeader     = "content-length"
+	ConnectionHeader        = "connection"
+	TransferEncodingHeader  = "transfer-encoding"
+	KeepAliveHeader         = "keep-alive"
+	CloseHeader             = "close"
+	UpgradeHeader           = "upgrade"
+	AcceptEncodingHeader    = "accept-encoding"
+	AcceptLanguageHeader    = "accept-language"
+	AcceptHeader            = "accept"
+	CacheControlHeader      = "cache-control"
+	AllowHeader             = "allow"
+	HostHeader              = "host"
+	ExpectHeader            = "expect"
+	TrailerHeader           = "trailer"
+	DateHeader              = "date"
+	ServerHeader            = "server"
+	ContentEncodingHeader   = "content-encoding"
+	ContentRangeHeader      = "content-range"
+	VaryHeader              = "vary"
+	ETagHeader              = "etag"
+	LocationHeader          = "location"
+	RangeHeader             = "range"
+	IfRangeHeader           = "if-range"
+	AcceptRangesHeader      = "accept-ranges"
+	LastModifiedHeader      = "last-modified"
+	IfMatchHeader           = "if-match"
+	IfNoneMatchHeader       = "if-none-match"
+	IfModifiedSinceHeader   = "if-modified-since"
+	IfUnmodifiedSinceHeader = "if-unmodified-since"
+	CookieHeader            = "cookie"
+	SetCookieHeader         = "set-cookie"
+
+	ContinueExpectation = "100-continue"
 )
@@ -0,0 +1,190 @@
+package httpx
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoCookie = errors.New("httpx: named cookie not present")
+
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute, leaving the choice to the browser.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone sends the cookie on cross-site requests too. Browsers
+	// only accept it on Secure cookies.
+	SameSiteNone
+)
+
+// Cookie is a cookie received in a Cookie header, which only carries Name
+// and Value, or one to send in a Set-Cookie header (RFC 6265).
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+
+	// Expires is omitted when zero.
+	Expires time.Time
+
+	// MaxAge is the lifetime in seconds. 0 omits the attribute, a negative
+	// value deletes the cookie right away.
+	MaxAge int
+
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+
+	// Partitioned keys the cookie to the top-level site (CHIPS). Browsers
+	// only accept it on Secure cookies.
+	Partitioned bool
+}
+
+// Cookies parses the Cookie headers of the request. Pairs with an invalid
+// name or value are skipped.
+func (r *HTTPRequest) Cookies() []*Cookie {
+	var cookies []*Cookie
+
+	for _, line := range r.Headers.Values(CookieHeader) {
+		for _, pair := range strings.Split(line, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				continue
+			}
+
+			name = strings.TrimSpace(name)
+			value, ok = parseCookieValue(strings.TrimSpace(value))
+			if !validToken(name) || !ok {
+				continue
+			}
+
+			cookies = append(cookies, &Cookie{Name: name, Value: value})
+		}
+	}
+
+	return cookies
+}
+
+// Cookie returns the first cookie named name, or ErrNoCookie.
+func (r *HTTPRequest) Cookie(name string) (*Cookie, error) {
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			return cookie, nil
+		}
+	}
+	return nil, ErrNoCookie
+}
+
+// parseCookieValue strips the optional quotes around value and checks that
+// it only holds cookie-octets.
+func parseCookieValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for i := 0; i < len(value); i++ {
+		if !validCookieValueByte(value[i]) {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+func validCookieValueByte(c byte) bool {
+	return c >= 0x20 && c < 0x7f && c != '"' && c != ';' && c != '\\'
+}
+
+// String returns the cookie serialised for a Set-Cookie header, or "" if
+// its name is not a valid token. Bytes that can't appear in a cookie are
+// dropped from the value and attributes.
+func (c *Cookie) String() string {
+	if !validToken(c.Name) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(sanitizeCookieValue(c.Value))
+
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(sanitizeCookieAttribute(c.Path))
+	}
+	if domain := strings.TrimPrefix(sanitizeCookieAttribute(c.Domain), "."); domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(domain)
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(c.Expires.UTC().Format(http.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+
+	switch c.SameSite {
+	case SameSiteLax:
+		b.WriteString("; SameSite=Lax")
+	case SameSiteStrict:
+		b.WriteString("; SameSite=Strict")
+	case SameSiteNone:
+		b.WriteString("; SameSite=None")
+	}
+
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String()
+}
+
+// sanitizeCookieValue drops invalid bytes and quotes values with a space or
+// comma, which some clients would otherwise split on.
+func sanitizeCookieValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 0x80 && validCookieValueByte(byte(r)) {
+			return r
+		}
+		return -1
+	}, value)
+
+	if strings.ContainsAny(value, " ,") {
+		return `"` + value + `"`
+	}
+	return value
+}
+
+func sanitizeCookieAttribute(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == ';' {
+			return -1
+		}
+		return r
+	}, value)
+}
+
+// SetCookie adds a Set-Cookie field for cookie to h, such as w.Header() or
+// &res.Headers. Each cookie gets its own field. Cookies with an invalid name
+// are dropped.
+func SetCookie(h *Header, cookie *Cookie) {
+	if value := cookie.String(); value != "" {
+		h.Add(SetCookieHeader, value)
+	}
+}
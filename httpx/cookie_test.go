@@ -0,0 +1,118 @@
+package httpx
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestCookies(t *testing.T) {
+	req := &HTTPRequest{Headers: NewHeader(
+		CookieHeader, `session=abc123; theme="dark"; empty=; bad name=x; novalue; utf=café`,
+		CookieHeader, "second=2",
+	)}
+
+	want := []struct{ name, value string }{
+		{"session", "abc123"},
+		{"theme", "dark"},
+		{"empty", ""},
+		{"second", "2"},
+	}
+
+	cookies := req.Cookies()
+	if len(cookies) != len(want) {
+		t.Fatalf("Expected %d cookies, got %d: %v", len(want), len(cookies), cookies)
+	}
+	for i, w := range want {
+		if cookies[i].Name != w.name || cookies[i].Value != w.value {
+			t.Errorf("Cookie %d: expected %s=%q, got %s=%q", i, w.name, w.value, cookies[i].Name, cookies[i].Value)
+		}
+	}
+
+	cookie, err := req.Cookie("theme")
+	if err != nil || cookie.Value != "dark" {
+		t.Errorf("Expected theme cookie, got %v, %v", cookie, err)
+	}
+	if _, err := req.Cookie("missing"); err != ErrNoCookie {
+		t.Errorf("Expected ErrNoCookie, got %v", err)
+	}
+}
+
+func TestCookieString(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("", 3600))
+
+	tests := []struct {
+		cookie Cookie
+		want   string
+	}{
+		{Cookie{Name: "a", Value: "b"}, "a=b"},
+		{Cookie{Name: "a", Value: "b c"}, `a="b c"`},
+		{Cookie{Name: "a", Value: "x;\"y\\z\x00"}, "a=xyz"},
+		{Cookie{Name: "bad name", Value: "b"}, ""},
+		{Cookie{Name: "a", Path: "/app;evil", Domain: ".example.com"}, "a=; Path=/appevil; Domain=example.com"},
+		{Cookie{Name: "a", Expires: expires}, "a=; Expires=Wed, 02 Jan 2030 02:04:05 GMT"},
+		{Cookie{Name: "a", MaxAge: 3600}, "a=; Max-Age=3600"},
+		{Cookie{Name: "a", MaxAge: -1}, "a=; Max-Age=0"},
+		{
+			Cookie{Name: "id", Value: "1", Secure: true, HttpOnly: true, SameSite: SameSiteNone, Partitioned: true},
+			"id=1; HttpOnly; Secure; SameSite=None; Partitioned",
+		},
+		{Cookie{Name: "a", SameSite: SameSiteLax}, "a=; SameSite=Lax"},
+		{Cookie{Name: "a", SameSite: SameSiteStrict}, "a=; SameSite=Strict"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cookie.String(); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
+
+func getCookieResponse(t *testing.T, addr string) *http.Response {
+	conn := makeRawConnection(t, addr)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return res
+}
+
+func TestSetCookieHeaders(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		res := &HTTPResponse{StatusCode: 200, Headers: NewHeader(), Body: strings.NewReader("ok")}
+		SetCookie(&res.Headers, &Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true})
+		SetCookie(&res.Headers, &Cookie{Name: "theme", Value: "dark", MaxAge: 60})
+		SetCookie(&res.Headers, &Cookie{Name: "in valid", Value: "x"})
+		return res
+	})
+	defer cleanup()
+
+	res := getCookieResponse(t, addr)
+	cookies := res.Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, got %v", res.Header.Values("Set-Cookie"))
+	}
+	if cookies[0].Name != "session" || !cookies[0].HttpOnly || cookies[0].Path != "/" {
+		t.Errorf("Unexpected first cookie %v", cookies[0])
+	}
+	if cookies[1].Name != "theme" || cookies[1].MaxAge != 60 {
+		t.Errorf("Unexpected second cookie %v", cookies[1])
+	}
+
+	// the client sends them back in one Cookie header
+	req := &http.Request{Header: http.Header{}}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	parsed := (&HTTPRequest{Headers: NewHeader(CookieHeader, req.Header.Get("Cookie"))}).Cookies()
+	if len(parsed) != 2 || parsed[1].Value != "dark" {
+		t.Errorf("Unexpected round trip %v", parsed)
+	}
+}
@@ -0,0 +1,30 @@
+package httpx
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dateCache formats the Date header at most once per second.
+type dateCache struct {
+	mu    sync.Mutex
+	unix  int64
+	value string
+}
+
+var serverDate dateCache
+
+func (c *dateCache) get(now time.Time) string {
+	sec := now.Unix()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sec != c.unix || c.value == "" {
+		c.unix = sec
+		c.value = now.UTC().Format(http.TimeFormat)
+	}
+
+	return c.value
+}
@@ -0,0 +1,306 @@
+package httpx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// mimeTypes covers the common web types so they don't depend on the
+// system's mime database. Other extensions fall back to mime.TypeByExtension
+// and then to sniffing the content.
+var mimeTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".htm":   "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".json":  "application/json",
+	".map":   "application/json",
+	".txt":   "text/plain; charset=utf-8",
+	".md":    "text/markdown; charset=utf-8",
+	".csv":   "text/csv; charset=utf-8",
+	".xml":   "application/xml",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".wasm":  "application/wasm",
+	".pdf":   "application/pdf",
+	".zip":   "application/zip",
+	".mp4":   "video/mp4",
+	".webm":  "video/webm",
+	".mp3":   "audio/mpeg",
+	".wav":   "audio/wav",
+}
+
+type FileServerConfig struct {
+	// Index is the file served for a directory. Defaults to "index.html".
+	Index string
+
+	// Browse lists the contents of directories without an index file.
+	// Otherwise they are answered with 404.
+	Browse bool
+
+	// Fallback is served, with status 200, for paths that don't exist and
+	// have no file extension, so client-side routes of a single page app
+	// load the app. Missing assets like /app.js still get 404.
+	Fallback string
+
+	// Precompressed serves name.gz instead of name, with
+	// Content-Encoding: gzip, when it exists and the client accepts gzip.
+	Precompressed bool
+}
+
+type fileServer struct {
+	fsys fs.FS
+	cfg  FileServerConfig
+}
+
+// FileServer returns a handler serving the files of fsys, such as
+// os.DirFS(dir) or an embed.FS, at the request path. Paths are cleaned
+// before use and can't leave fsys. Use StripPrefix to mount it below a path.
+func FileServer(fsys fs.FS, cfg FileServerConfig) HandlerFunc {
+	if cfg.Index == "" {
+		cfg.Index = "index.html"
+	}
+
+	f := &fileServer{fsys: fsys, cfg: cfg}
+	return f.serve
+}
+
+func (f *fileServer) serve(req *HTTPRequest) *HTTPResponse {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		res := textResponse(http.StatusMethodNotAllowed, "Method Not Allowed")
+		res.Headers.Set(AllowHeader, "GET, HEAD")
+		return res
+	}
+
+	name, ok := cleanFilePath(req.Path)
+	if !ok {
+		return textResponse(http.StatusBadRequest, "Bad Request")
+	}
+
+	file, info, err := f.open(name)
+	if errors.Is(err, fs.ErrNotExist) && f.cfg.Fallback != "" && path.Ext(name) == "" {
+		name = strings.TrimPrefix(f.cfg.Fallback, "/")
+		file, info, err = f.open(name)
+	}
+	if err != nil {
+		return fileErrorResponse(err)
+	}
+
+	if !info.IsDir() {
+		return preconditionResponse(req, f.fileResponse(req, name, file, info))
+	}
+	file.Close()
+
+	// relative links in the page only resolve against a trailing slash
+	if !strings.HasSuffix(req.Path, "/") {
+		// the decoded segment is escaped again, so "a%3Fb" doesn't come back
+		// as a path with a query
+		location := &url.URL{Path: path.Base(req.Path) + "/", RawQuery: req.RawQuery}
+		return redirectResponse(location.String())
+	}
+
+	index := path.Join(name, f.cfg.Index)
+	if file, info, err := f.open(index); err == nil {
+		if !info.IsDir() {
+			return preconditionResponse(req, f.fileResponse(req, index, file, info))
+		}
+		file.Close()
+	}
+
+	if f.cfg.Browse {
+		return f.listing(req, name)
+	}
+	return textResponse(http.StatusNotFound, "Not Found")
+}
+
+// cleanFilePath turns a request path into a name valid for fs.FS. Dot-dot
+// segments are resolved against the root, so they can't escape it.
+func cleanFilePath(p string) (string, bool) {
+	if strings.ContainsAny(p, "\\\x00") {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if name == "" {
+		name = "."
+	}
+
+	return name, fs.ValidPath(name)
+}
+
+func (f *fileServer) open(name string) (fs.File, fs.FileInfo, error) {
+	file, err := f.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, info, nil
+}
+
+func (f *fileServer) fileResponse(req *HTTPRequest, name string, file fs.File, info fs.FileInfo) *HTTPResponse {
+	headers := NewHeader()
+
+	if f.cfg.Precompressed && path.Ext(name) != ".gz" {
+		headers.Set(VaryHeader, "Accept-Encoding")
+
+		if negotiateEncoding(req.Headers.Values(AcceptEncodingHeader), []string{"gzip"}) == "gzip" {
+			if gz, gzInfo, err := f.open(name + ".gz"); err == nil {
+				if gzInfo.Mode().IsRegular() {
+					headers.Set(ContentEncodingHeader, "gzip")
+					headers.Set(ContentTypeHeader, contentTypeByName(name, file))
+					file.Close()
+					return fileBodyResponse(headers, gz, gzInfo)
+				}
+				gz.Close()
+			}
+		}
+	}
+
+	contentType, body := sniffContentType(name, file)
+	headers.Set(ContentTypeHeader, contentType)
+
+	res := fileBodyResponse(headers, file, info)
+	res.Body = body
+	return res
+}
+
+func fileBodyResponse(headers Header, file fs.File, info fs.FileInfo) *HTTPResponse {
+	length := info.Size()
+	if length == 0 {
+		// 0 would mean "detect from the body"
+		length = -1
+		if _, ok := file.(io.Seeker); ok {
+			length = 0
+		}
+	}
+
+	if !info.ModTime().IsZero() {
+		headers.Set(LastModifiedHeader, info.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if etag := FileETag(info); etag != "" {
+		headers.Set(ETagHeader, etag)
+	}
+	headers.Set(AcceptRangesHeader, "bytes")
+
+	return &HTTPResponse{
+		StatusCode:    http.StatusOK,
+		Headers:       headers,
+		Body:          file,
+		ContentLength: length,
+		closeBody:     true,
+	}
+}
+
+// contentTypeByName returns the type for name's extension, or sniffs the
+// start of file when the extension is unknown.
+func contentTypeByName(name string, file fs.File) string {
+	contentType, _ := sniffContentType(name, file)
+	return contentType
+}
+
+// sniffContentType determines the type of name and returns a reader for the
+// whole file. Seekable files are rewound after sniffing so that the file
+// itself can still be sent with sendfile.
+func sniffContentType(name string, file fs.File) (string, io.Reader) {
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := mimeTypes[ext]; ok {
+		return contentType, file
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, file
+	}
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	contentType := http.DetectContentType(buf[:n])
+
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err == nil {
+			return contentType, file
+		}
+	}
+
+	return contentType, readCloser{io.MultiReader(bytes.NewReader(buf[:n]), file), file}
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (f *fileServer) listing(req *HTTPRequest, name string) *HTTPResponse {
+	entries, err := fs.ReadDir(f.fsys, name)
+	if err != nil {
+		return fileErrorResponse(err)
+	}
+
+	title := html.EscapeString(req.Path)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<!doctype html>\n<meta charset=\"utf-8\">\n<title>Index of %s</title>\n", title)
+	fmt.Fprintf(&buf, "<h1>Index of %s</h1>\n<ul>\n", title)
+	if name != "." {
+		buf.WriteString("<li><a href=\"../\">../</a></li>\n")
+	}
+
+	for _, entry := range entries {
+		entryName := entry.Name()
+		if entry.IsDir() {
+			entryName += "/"
+		}
+		link := url.URL{Path: entryName}
+		fmt.Fprintf(&buf, "<li><a href=\"%s\">%s</a></li>\n",
+			html.EscapeString(link.String()), html.EscapeString(entryName))
+	}
+	buf.WriteString("</ul>\n")
+
+	return &HTTPResponse{
+		StatusCode: http.StatusOK,
+		Headers:    NewHeader(ContentTypeHeader, "text/html; charset=utf-8"),
+		Body:       bytes.NewReader(buf.Bytes()),
+	}
+}
+
+func fileErrorResponse(err error) *HTTPResponse {
+	switch {
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrInvalid):
+		return textResponse(http.StatusNotFound, "Not Found")
+	case errors.Is(err, fs.ErrPermission):
+		return textResponse(http.StatusForbidden, "Forbidden")
+	}
+
+	fmt.Printf("Error serving file: %v\n", err)
+	return textResponse(http.StatusInternalServerError, "Internal Server Error")
+}
+
+func redirectResponse(location string) *HTTPResponse {
+	res := textResponse(http.StatusMovedPermanently, "Moved Permanently")
+	res.Headers.Set(LocationHeader, location)
+	return res
+}
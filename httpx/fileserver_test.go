@@ -0,0 +1,323 @@
+package httpx
+
+import (
+	"bufio"
+	"embed"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+//go:embed testdata/static
+var embeddedStatic embed.FS
+
+func serveFile(t *testing.T, handler HandlerFunc, method, target string, headers ...string) (*HTTPResponse, string) {
+	req := &HTTPRequest{Method: method, Version: HTTP11Version, Headers: NewHeader(headers...)}
+	if err := req.parseTarget(target); err != nil {
+		t.Fatalf("Invalid test path %q: %v", target, err)
+	}
+
+	res := handler(req)
+
+	body := ""
+	if res.Body != nil {
+		data, _ := io.ReadAll(res.Body)
+		body = string(data)
+		if closer, ok := res.Body.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	return res, body
+}
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":       {Data: []byte("<h1>home</h1>")},
+		"css/site.css":     {Data: []byte("body{}")},
+		"notes":            {Data: []byte("plain words without an extension")},
+		"image":            {Data: []byte("\x89PNG\r\n\x1a\n\x00\x00")},
+		"docs/a <b>.txt":   {Data: []byte("a")},
+		"docs/sub/x.txt":   {Data: []byte("x")},
+		"app.js":           {Data: []byte("console.log('plain')")},
+		"app.js.gz":        {Data: []byte("gzipped bytes")},
+		"empty.txt":        {Data: []byte{}},
+		"nested/deep.json": {Data: []byte(`{}`)},
+	}
+}
+
+func TestFileServerContentTypes(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{})
+
+	tests := []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"/css/site.css", "text/css; charset=utf-8", "body{}"},
+		{"/nested/deep.json", "application/json", "{}"},
+		{"/notes", "text/plain; charset=utf-8", "plain words without an extension"},
+		{"/image", "image/png", "\x89PNG\r\n\x1a\n\x00\x00"},
+		{"/empty.txt", "text/plain; charset=utf-8", ""},
+	}
+
+	for _, tt := range tests {
+		res, body := serveFile(t, handler, "GET", tt.path)
+
+		if res.StatusCode != 200 {
+			t.Errorf("%s: expected 200, got %d", tt.path, res.StatusCode)
+		}
+		if contentType := res.Headers.Get(ContentTypeHeader); contentType != tt.contentType {
+			t.Errorf("%s: expected content-type %q, got %q", tt.path, tt.contentType, contentType)
+		}
+		if res.Headers.Get(AcceptRangesHeader) != "bytes" {
+			t.Errorf("%s: expected Accept-Ranges bytes, got %v", tt.path, res.Headers)
+		}
+		if body != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.path, tt.body, body)
+		}
+	}
+}
+
+func TestFileServerDirectories(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{})
+
+	if res, body := serveFile(t, handler, "GET", "/"); res.StatusCode != 200 || body != "<h1>home</h1>" {
+		t.Errorf("Expected index.html for the root, got %d %q", res.StatusCode, body)
+	}
+
+	res, _ := serveFile(t, handler, "GET", "/docs?sort=name")
+	if res.StatusCode != 301 || res.Headers.Get(LocationHeader) != "docs/?sort=name" {
+		t.Errorf("Expected redirect to the trailing slash, got %d %v", res.StatusCode, res.Headers)
+	}
+
+	if res, _ := serveFile(t, handler, "GET", "/docs/"); res.StatusCode != 404 {
+		t.Errorf("Expected 404 for a directory without index, got %d", res.StatusCode)
+	}
+}
+
+func TestFileServerRedirectIsEscaped(t *testing.T) {
+	handler := FileServer(fstest.MapFS{
+		"a?b/x.txt": {Data: []byte("x")},
+		"c d/x.txt": {Data: []byte("x")},
+		"e:f/x.txt": {Data: []byte("x")},
+		"g%h/x.txt": {Data: []byte("x")},
+	}, FileServerConfig{})
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{"/a%3Fb", "a%3Fb/"},
+		{"/c%20d?q=1", "c%20d/?q=1"},
+		{"/e:f", "./e:f/"},
+		{"/g%25h", "g%25h/"},
+	}
+
+	for _, tt := range tests {
+		res, _ := serveFile(t, handler, "GET", tt.target)
+		if res.StatusCode != 301 || res.Headers.Get(LocationHeader) != tt.location {
+			t.Errorf("%s: expected redirect to %q, got %d %q", tt.target, tt.location, res.StatusCode, res.Headers.Get(LocationHeader))
+		}
+	}
+}
+
+func TestFileServerListing(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{Browse: true})
+
+	res, body := serveFile(t, handler, "GET", "/docs/")
+
+	if res.StatusCode != 200 || !strings.HasPrefix(res.Headers.Get(ContentTypeHeader), "text/html") {
+		t.Fatalf("Expected HTML listing, got %d %v", res.StatusCode, res.Headers)
+	}
+	if !strings.Contains(body, `<a href="a%20%3Cb%3E.txt">a &lt;b&gt;.txt</a>`) {
+		t.Errorf("Expected escaped file entry, got: %s", body)
+	}
+	if !strings.Contains(body, `<a href="sub/">sub/</a>`) || !strings.Contains(body, `<a href="../">`) {
+		t.Errorf("Expected directory entries, got: %s", body)
+	}
+}
+
+func TestFileServerTraversal(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "secret.txt"), []byte("secret"), 0o644)
+	os.Mkdir(filepath.Join(dir, "public"), 0o755)
+	os.WriteFile(filepath.Join(dir, "public", "ok.txt"), []byte("ok"), 0o644)
+
+	handler := FileServer(os.DirFS(filepath.Join(dir, "public")), FileServerConfig{})
+
+	for _, target := range []string{"/../secret.txt", "/%2e%2e/secret.txt", "/a/../../secret.txt", "/..%5csecret.txt"} {
+		res, body := serveFile(t, handler, "GET", target)
+		if res.StatusCode == 200 || strings.Contains(body, "secret") {
+			t.Errorf("%s: expected file outside the root to be unreachable, got %d %q", target, res.StatusCode, body)
+		}
+	}
+
+	if res, body := serveFile(t, handler, "GET", "/a/../ok.txt"); res.StatusCode != 200 || body != "ok" {
+		t.Errorf("Expected cleaned path inside the root to be served, got %d %q", res.StatusCode, body)
+	}
+}
+
+func TestFileServerFallback(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{Fallback: "index.html"})
+
+	if res, body := serveFile(t, handler, "GET", "/settings/profile"); res.StatusCode != 200 || body != "<h1>home</h1>" {
+		t.Errorf("Expected fallback page for client-side route, got %d %q", res.StatusCode, body)
+	}
+	if res, _ := serveFile(t, handler, "GET", "/missing.js"); res.StatusCode != 404 {
+		t.Errorf("Expected 404 for a missing asset, got %d", res.StatusCode)
+	}
+}
+
+func TestFileServerPrecompressed(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{Precompressed: true})
+
+	res, body := serveFile(t, handler, "GET", "/app.js", AcceptEncodingHeader, "br, gzip")
+	if res.Headers.Get(ContentEncodingHeader) != "gzip" || body != "gzipped bytes" {
+		t.Errorf("Expected precompressed file, got %v %q", res.Headers, body)
+	}
+	if res.Headers.Get(ContentTypeHeader) != "text/javascript; charset=utf-8" {
+		t.Errorf("Expected type of the original file, got %q", res.Headers.Get(ContentTypeHeader))
+	}
+	if res.Headers.Get(VaryHeader) != "Accept-Encoding" {
+		t.Errorf("Expected Vary header, got %v", res.Headers)
+	}
+
+	res, body = serveFile(t, handler, "GET", "/app.js")
+	if res.Headers.Has(ContentEncodingHeader) || body != "console.log('plain')" {
+		t.Errorf("Expected plain file without Accept-Encoding, got %v %q", res.Headers, body)
+	}
+}
+
+func TestFileServerMethods(t *testing.T) {
+	handler := FileServer(testFS(), FileServerConfig{})
+
+	res, _ := serveFile(t, handler, "POST", "/app.js")
+	if res.StatusCode != 405 || res.Headers.Get(AllowHeader) != "GET, HEAD" {
+		t.Errorf("Expected 405 with Allow, got %d %v", res.StatusCode, res.Headers)
+	}
+}
+
+func TestFileServerEmbedFS(t *testing.T) {
+	static, err := fs.Sub(embeddedStatic, "testdata/static")
+	if err != nil {
+		t.Fatalf("Failed to open embedded files: %v", err)
+	}
+
+	router := NewRouter()
+	router.Get("/static/*path", StripPrefix("/static", FileServer(static, FileServerConfig{})))
+
+	_, addr, cleanup := setupTestServer(t, router.ServeRequest)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte("GET /static/css/site.css HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /static/ HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	_, headers := readResponseHead(t, reader)
+	if headers[ContentTypeHeader] != "text/css; charset=utf-8" || headers[ContentLengthHeader] != "20" {
+		t.Errorf("Expected embedded stylesheet, got: %v", headers)
+	}
+
+	css := make([]byte, 20)
+	io.ReadFull(reader, css)
+	if string(css) != "body { margin: 0; }\n" {
+		t.Errorf("Unexpected stylesheet: %q", css)
+	}
+
+	_, headers = readResponseHead(t, reader)
+	index, _ := io.ReadAll(reader)
+	if !strings.HasPrefix(headers[ContentTypeHeader], "text/html") || !strings.Contains(string(index), "<title>static</title>") {
+		t.Errorf("Expected embedded index page, got: %v %q", headers, index)
+	}
+}
+
+func TestStripPrefixSharesRequest(t *testing.T) {
+	var inner *HTTPRequest
+	handler := StripPrefix("/upload", func(req *HTTPRequest) *HTTPResponse {
+		inner = req
+		body, _ := io.ReadAll(req.Body)
+		return textResponse(200, req.Path+" "+string(body)+" "+req.Trailer.Get("x-checksum"))
+	})
+
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		res := handler(req)
+		if inner != req || req.Path != "/upload/file" {
+			t.Errorf("Expected the original request with its path restored, got %q", req.Path)
+		}
+		return res
+	})
+	defer cleanup()
+
+	response := makeRequest(t, addr, "POST /upload/file HTTP/1.1\r\nHost: localhost\r\n"+
+		"Trailer: X-Checksum\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n"+
+		"5\r\nhello\r\n0\r\nX-Checksum: abc123\r\n\r\n")
+
+	if !strings.HasSuffix(response, "\r\n\r\n/file hello abc123") {
+		t.Errorf("Expected stripped path and trailer, got: %q", response)
+	}
+}
+
+func TestStripPrefixRedirectsPrefix(t *testing.T) {
+	router := NewRouter()
+	router.Get("/static/*path", StripPrefix("/static", FileServer(testFS(), FileServerConfig{})))
+
+	res, _ := serveFile(t, router.ServeRequest, "GET", "/static?v=1")
+	if res.StatusCode != 301 || res.Headers.Get(LocationHeader) != "static/?v=1" {
+		t.Errorf("Expected redirect to the prefix directory, got %d %q", res.StatusCode, res.Headers.Get(LocationHeader))
+	}
+
+	res, body := serveFile(t, router.ServeRequest, "GET", "/static/")
+	if res.StatusCode != 200 || !strings.Contains(body, "<h1>home</h1>") {
+		t.Errorf("Expected the index below the prefix, got %d %q", res.StatusCode, body)
+	}
+}
+
+type closeCountingFS struct {
+	fs.FS
+	opened, closed atomic.Int32
+}
+
+type closeCountingFile struct {
+	fs.File
+	fsys *closeCountingFS
+}
+
+func (f closeCountingFile) Close() error {
+	f.fsys.closed.Add(1)
+	return f.File.Close()
+}
+
+func (c *closeCountingFS) Open(name string) (fs.File, error) {
+	file, err := c.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	c.opened.Add(1)
+	return closeCountingFile{File: file, fsys: c}, nil
+}
+
+func TestFileServerClosesFiles(t *testing.T) {
+	fsys := &closeCountingFS{FS: testFS()}
+	_, addr, cleanup := setupTestServer(t, FileServer(fsys, FileServerConfig{}))
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET /notes HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if !strings.HasSuffix(response, "plain words without an extension") {
+		t.Fatalf("Unexpected response: %q", response)
+	}
+
+	if opened, closed := fsys.opened.Load(), fsys.closed.Load(); opened == 0 || opened != closed {
+		t.Errorf("Expected every opened file to be closed, opened %d and closed %d", opened, closed)
+	}
+}
@@ -0,0 +1,375 @@
+package httpx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"slices"
+)
+
+var (
+	ErrNotMultipart = errors.New("httpx: request is not multipart/form-data")
+	ErrBodyConsumed = errors.New("httpx: request body has already been parsed")
+	ErrPartTooLarge = errors.New("httpx: multipart part exceeds MaxPartSize")
+	ErrFormTooLarge = errors.New("httpx: multipart form exceeds its size limit")
+	ErrTooManyParts = errors.New("httpx: multipart form has too many parts")
+	ErrMissingFile  = errors.New("httpx: no such file in multipart form")
+)
+
+const (
+	urlencodedType    = "application/x-www-form-urlencoded"
+	multipartFormType = "multipart/form-data"
+)
+
+// MultipartConfig limits what is read from a multipart/form-data body. The
+// request size limit of the server or route applies on top.
+type MultipartConfig struct {
+	// MaxPartSize limits the body of each part. 0 means no limit.
+	MaxPartSize int64
+
+	// MaxTotalSize limits the bodies of all parts together. 0 means no
+	// limit.
+	MaxTotalSize int64
+
+	// MaxParts defaults to DefaultMultipartMaxParts.
+	MaxParts int
+
+	// MaxMemory is how much of a form MultipartForm keeps in memory.
+	// Field values count against it and must fit; files that don't are
+	// spooled to temporary files. Defaults to DefaultMultipartMemory.
+	MaxMemory int64
+
+	// TempDir holds spooled files. Defaults to os.TempDir().
+	TempDir string
+}
+
+// formState is shared by every copy of a request, so a wrapper handing its
+// handler a copy still parses the body once, and the files spooled through
+// the copy are removed by the server.
+type formState struct {
+	form          url.Values
+	multipartForm *MultipartForm
+	bodyParsed    bool
+	tempFiles     []string
+}
+
+// formState returns the form state, creating it for requests that weren't
+// read by the server.
+func (r *HTTPRequest) formState() *formState {
+	if r.forms == nil {
+		r.forms = &formState{}
+	}
+	return r.forms
+}
+
+// Form parses an application/x-www-form-urlencoded body, or returns the
+// field values of a multipart/form-data body read with the default limits
+// of MultipartForm. Other bodies give empty values. Query parameters are in
+// Query().
+func (r *HTTPRequest) Form() (url.Values, error) {
+	state := r.formState()
+	if state.form != nil {
+		return state.form, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Headers.Get(ContentTypeHeader))
+	switch mediaType {
+	case multipartFormType:
+		form, err := r.MultipartForm(MultipartConfig{})
+		if err != nil {
+			return nil, err
+		}
+		state.form = form.Value
+		return state.form, nil
+
+	case urlencodedType:
+		if state.bodyParsed {
+			return nil, ErrBodyConsumed
+		}
+		state.bodyParsed = true
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid form body: %v", err)
+		}
+		state.form = values
+		return state.form, nil
+	}
+
+	state.form = url.Values{}
+	return state.form, nil
+}
+
+// FormValue returns the first value for key from Form, or "".
+func (r *HTTPRequest) FormValue(key string) string {
+	form, err := r.Form()
+	if err != nil {
+		return ""
+	}
+	return form.Get(key)
+}
+
+// MultipartReader returns a reader yielding the parts of a
+// multipart/form-data body one at a time, without buffering them. Use it
+// instead of MultipartForm to stream uploads.
+func (r *HTTPRequest) MultipartReader(cfg MultipartConfig) (*MultipartReader, error) {
+	state := r.formState()
+	if state.bodyParsed {
+		return nil, ErrBodyConsumed
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get(ContentTypeHeader))
+	if err != nil || mediaType != multipartFormType {
+		return nil, ErrNotMultipart
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, ErrNotMultipart
+	}
+
+	if cfg.MaxParts == 0 {
+		cfg.MaxParts = DefaultMultipartMaxParts
+	}
+
+	state.bodyParsed = true
+	return &MultipartReader{reader: multipart.NewReader(r.Body, boundary), cfg: cfg}, nil
+}
+
+type MultipartReader struct {
+	reader *multipart.Reader
+	cfg    MultipartConfig
+	parts  int
+	total  int64
+}
+
+// Part is one part of a multipart body. Read returns its decoded content,
+// and ErrPartTooLarge or ErrFormTooLarge once it exceeds a limit.
+type Part struct {
+	FormName string
+	FileName string
+	Header   Header
+
+	mr   *MultipartReader
+	part *multipart.Part
+	read int64
+}
+
+// NextPart returns the next part, skipping what is left of the previous one,
+// or io.EOF after the last part.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	part, err := mr.reader.NextPart()
+	if err != nil {
+		return nil, err
+	}
+
+	mr.parts++
+	if mr.parts > mr.cfg.MaxParts {
+		return nil, ErrTooManyParts
+	}
+
+	header := NewHeader()
+	keys := make([]string, 0, len(part.Header))
+	for key := range part.Header {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		for _, value := range part.Header[key] {
+			header.Add(key, value)
+		}
+	}
+
+	return &Part{
+		FormName: part.FormName(),
+		FileName: part.FileName(),
+		Header:   header,
+		mr:       mr,
+		part:     part,
+	}, nil
+}
+
+func (p *Part) Read(b []byte) (int, error) {
+	allowed, limitErr := int64(-1), error(nil)
+	if max := p.mr.cfg.MaxPartSize; max > 0 {
+		allowed, limitErr = max-p.read, ErrPartTooLarge
+	}
+	if max := p.mr.cfg.MaxTotalSize; max > 0 && (allowed < 0 || max-p.mr.total < allowed) {
+		allowed, limitErr = max-p.mr.total, ErrFormTooLarge
+	}
+
+	if allowed == 0 {
+		// at the limit, which is only a problem if there is more
+		var probe [1]byte
+		n, err := p.part.Read(probe[:])
+		if n > 0 {
+			return 0, limitErr
+		}
+		return 0, err
+	}
+	if allowed > 0 && int64(len(b)) > allowed {
+		b = b[:allowed]
+	}
+
+	n, err := p.part.Read(b)
+	p.read += int64(n)
+	p.mr.total += int64(n)
+	return n, err
+}
+
+// MultipartForm is a multipart/form-data body read in full.
+type MultipartForm struct {
+	Value url.Values
+	File  map[string][]*FormFile
+}
+
+// FormFile is an uploaded file of a MultipartForm, held in memory or in a
+// temporary file that is removed once the handler returns.
+type FormFile struct {
+	FileName string
+	Header   Header
+	Size     int64
+
+	content []byte
+	path    string
+}
+
+// Open returns the content of the file.
+func (f *FormFile) Open() (io.ReadSeekCloser, error) {
+	if f.path != "" {
+		return os.Open(f.path)
+	}
+	return nopReadSeekCloser{bytes.NewReader(f.content)}, nil
+}
+
+type nopReadSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopReadSeekCloser) Close() error {
+	return nil
+}
+
+// FormFile returns the first file uploaded under key.
+func (r *HTTPRequest) FormFile(key string) (*FormFile, error) {
+	form, err := r.MultipartForm(MultipartConfig{})
+	if err != nil {
+		return nil, err
+	}
+	if files := form.File[key]; len(files) > 0 {
+		return files[0], nil
+	}
+	return nil, ErrMissingFile
+}
+
+// MultipartForm reads a whole multipart/form-data body. Files beyond
+// MaxMemory are spooled to temporary files, which are removed after the
+// handler returns. The form is kept, so later calls return it regardless of
+// cfg.
+func (r *HTTPRequest) MultipartForm(cfg MultipartConfig) (*MultipartForm, error) {
+	state := r.formState()
+	if state.multipartForm != nil {
+		return state.multipartForm, nil
+	}
+
+	mr, err := r.MultipartReader(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	form := &MultipartForm{Value: url.Values{}, File: map[string][]*FormFile{}}
+
+	memory := cfg.MaxMemory
+	if memory == 0 {
+		memory = DefaultMultipartMemory
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			state.multipartForm = form
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FileName == "" {
+			// one byte more than fits tells an exact fit from an overflow
+			var value bytes.Buffer
+			n, err := io.CopyN(&value, part, memory+1)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if n > memory {
+				return nil, ErrFormTooLarge
+			}
+			memory -= n
+			form.Value.Add(part.FormName, value.String())
+			continue
+		}
+
+		file, err := r.readFormFile(part, &memory, cfg.TempDir)
+		if err != nil {
+			return nil, err
+		}
+		form.File[part.FormName] = append(form.File[part.FormName], file)
+	}
+}
+
+// readFormFile keeps a file part in memory while it fits, and spools it to a
+// temporary file once it doesn't.
+func (r *HTTPRequest) readFormFile(part *Part, memory *int64, tempDir string) (*FormFile, error) {
+	state := r.formState()
+	file := &FormFile{FileName: part.FileName, Header: part.Header}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *memory+1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if n <= *memory {
+		*memory -= n
+		file.content = buf.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp(tempDir, "httpx-upload-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file: %v", err)
+	}
+	file.path = tmp.Name()
+	state.tempFiles = append(state.tempFiles, file.path)
+
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	file.Size = size
+	return file, nil
+}
+
+// removeTempFiles deletes the files spooled by MultipartForm.
+func (r *HTTPRequest) removeTempFiles() {
+	state := r.formState()
+	for _, path := range state.tempFiles {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Error removing temporary file: %v\n", err)
+		}
+	}
+	state.tempFiles = nil
+}
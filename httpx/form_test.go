@@ -0,0 +1,270 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postBody(t *testing.T, addr, contentType string, body []byte) string {
+	return postBodyTo(t, addr, "/", contentType, body)
+}
+
+func postBodyTo(t *testing.T, addr, target, contentType string, body []byte) string {
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte("POST " + target + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n" +
+		"Content-Type: " + contentType + "\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n"))
+	conn.Write(body)
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "POST"})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer res.Body.Close()
+
+	data, _ := io.ReadAll(res.Body)
+	return string(data)
+}
+
+type testPart struct {
+	name, filename, content string
+}
+
+func multipartBody(t *testing.T, parts ...testPart) (string, []byte) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename != "" {
+			w, err = writer.CreateFormFile(p.name, p.filename)
+		} else {
+			w, err = writer.CreateFormField(p.name)
+		}
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		w.Write([]byte(p.content))
+	}
+	writer.Close()
+
+	return writer.FormDataContentType(), body.Bytes()
+}
+
+func TestURLEncodedForm(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		form, err := req.Form()
+		if err != nil {
+			return textResponse(400, err.Error())
+		}
+		return textResponse(200, fmt.Sprintf("%s|%s|%d", req.FormValue("name"), form.Get("note"), len(form["tag"])))
+	})
+	defer cleanup()
+
+	body := postBody(t, addr, "application/x-www-form-urlencoded", []byte("name=Ann+Lee&note=a%26b&tag=x&tag=y"))
+	if body != "Ann Lee|a&b|2" {
+		t.Errorf("Unexpected form values %q", body)
+	}
+
+	body = postBody(t, addr, "text/plain", []byte("name=ignored"))
+	if body != "||0" {
+		t.Errorf("Expected other bodies to give no values, got %q", body)
+	}
+}
+
+func TestMultipartReaderStreamsParts(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		mr, err := req.MultipartReader(MultipartConfig{})
+		if err != nil {
+			return textResponse(400, err.Error())
+		}
+
+		var out strings.Builder
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return textResponse(400, err.Error())
+			}
+			data, _ := io.ReadAll(part)
+			fmt.Fprintf(&out, "%s:%s:%s:%s;", part.FormName, part.FileName, part.Header.Get(ContentTypeHeader), data)
+		}
+		return textResponse(200, out.String())
+	})
+	defer cleanup()
+
+	contentType, body := multipartBody(t,
+		testPart{name: "title", content: "hello"},
+		testPart{name: "upload", filename: "a.txt", content: "file data"},
+	)
+	got := postBody(t, addr, contentType, body)
+	want := "title:::hello;upload:a.txt:application/octet-stream:file data;"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if got := postBody(t, addr, "application/json", []byte("{}")); got != ErrNotMultipart.Error() {
+		t.Errorf("Expected ErrNotMultipart, got %q", got)
+	}
+}
+
+func TestMultipartLimits(t *testing.T) {
+	var cfg MultipartConfig
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		mr, err := req.MultipartReader(cfg)
+		if err != nil {
+			return textResponse(400, err.Error())
+		}
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return textResponse(200, "ok")
+			}
+			if err == nil {
+				_, err = io.Copy(io.Discard, part)
+			}
+			if err != nil {
+				return textResponse(413, err.Error())
+			}
+		}
+	})
+	defer cleanup()
+
+	contentType, body := multipartBody(t,
+		testPart{name: "a", content: strings.Repeat("a", 10)},
+		testPart{name: "b", content: strings.Repeat("b", 10)},
+	)
+
+	tests := []struct {
+		cfg  MultipartConfig
+		want string
+	}{
+		{MultipartConfig{MaxPartSize: 10, MaxTotalSize: 20, MaxParts: 2}, "ok"},
+		{MultipartConfig{MaxPartSize: 9}, ErrPartTooLarge.Error()},
+		{MultipartConfig{MaxTotalSize: 15}, ErrFormTooLarge.Error()},
+		{MultipartConfig{MaxParts: 1}, ErrTooManyParts.Error()},
+	}
+
+	for _, tt := range tests {
+		cfg = tt.cfg
+		if got := postBody(t, addr, contentType, body); got != tt.want {
+			t.Errorf("%+v: expected %q, got %q", tt.cfg, tt.want, got)
+		}
+	}
+}
+
+func TestMultipartFormSpoolsLargeFiles(t *testing.T) {
+	dir := t.TempDir()
+	var spooled string
+
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		form, err := req.MultipartForm(MultipartConfig{MaxMemory: 100, TempDir: dir})
+		if err != nil {
+			return textResponse(400, err.Error())
+		}
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) == 1 {
+			spooled = entries[0].Name()
+		}
+
+		var out strings.Builder
+		out.WriteString(form.Value.Get("title") + ";")
+		for _, name := range []string{"small", "large"} {
+			file, err := req.FormFile(name)
+			if err != nil {
+				return textResponse(400, err.Error())
+			}
+			r, _ := file.Open()
+			data, _ := io.ReadAll(r)
+			r.Close()
+			fmt.Fprintf(&out, "%s:%d:%d;", file.FileName, file.Size, len(data))
+		}
+		if _, err := req.FormFile("missing"); !errors.Is(err, ErrMissingFile) {
+			return textResponse(400, "expected ErrMissingFile")
+		}
+		return textResponse(200, out.String())
+	})
+	defer cleanup()
+
+	contentType, body := multipartBody(t,
+		testPart{name: "title", content: "report"},
+		testPart{name: "small", filename: "s.txt", content: "tiny"},
+		testPart{name: "large", filename: "l.bin", content: strings.Repeat("x", 500)},
+	)
+
+	if got := postBody(t, addr, contentType, body); got != "report;s.txt:4:4;l.bin:500:500;" {
+		t.Errorf("Unexpected form %q", got)
+	}
+	if spooled == "" {
+		t.Error("Expected the large file to be spooled to disk")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("Expected temporary files to be removed, found %d", len(entries))
+	}
+}
+
+func TestMultipartFormFieldTooLarge(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		if _, err := req.MultipartForm(MultipartConfig{MaxMemory: 8}); err != nil {
+			return textResponse(413, err.Error())
+		}
+		return textResponse(200, "ok")
+	})
+	defer cleanup()
+
+	contentType, body := multipartBody(t, testPart{name: "note", content: "more than eight bytes"})
+	if got := postBody(t, addr, contentType, body); got != ErrFormTooLarge.Error() {
+		t.Errorf("Expected ErrFormTooLarge, got %q", got)
+	}
+}
+
+func TestSpooledFilesRemovedForCopiedRequests(t *testing.T) {
+	dir := t.TempDir()
+
+	spool := func(req *HTTPRequest) *HTTPResponse {
+		if _, err := req.MultipartForm(MultipartConfig{MaxMemory: 1, TempDir: dir}); err != nil {
+			return textResponse(400, err.Error())
+		}
+		entries, _ := os.ReadDir(dir)
+		return textResponse(200, strconv.Itoa(len(entries)))
+	}
+
+	handlers := map[string]HandlerFunc{
+		"StripPrefix": StripPrefix("/upload", spool),
+		"copy": func(req *HTTPRequest) *HTTPResponse {
+			copied := *req
+			return spool(&copied)
+		},
+	}
+
+	contentType, body := multipartBody(t, testPart{name: "file", filename: "f.txt", content: "spooled content"})
+
+	for name, handler := range handlers {
+		_, addr, cleanup := setupTestServer(t, handler)
+		data := postBodyTo(t, addr, "/upload/file", contentType, body)
+		cleanup()
+
+		if data != "1" {
+			t.Errorf("%s: expected one spooled file during the handler, got %q", name, data)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: expected temporary files to be removed, found %d", name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package httpx
+
+import (
+	"net/textproto"
+	"strings"
+)
+
+type HeaderField struct {
+	Name  string
+	Value string
+}
+
+// Header is an ordered list of header fields. Lookups are case-insensitive,
+// repeated fields are kept in the order they were added or received, and
+// names are written in canonical form.
+type Header []HeaderField
+
+var headerValueReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// NewHeader builds a Header from alternating name and value arguments.
+func NewHeader(pairs ...string) Header {
+	h := make(Header, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+// forbiddenTrailer reports whether name controls message framing, routing or
+// authentication and must not be taken from a trailer (RFC 9110 6.5.1).
+func forbiddenTrailer(name string) bool {
+	switch strings.ToLower(name) {
+	case ContentLengthHeader, TransferEncodingHeader, TrailerHeader, HostHeader,
+		ContentTypeHeader, ConnectionHeader, KeepAliveHeader, ExpectHeader,
+		ContentEncodingHeader, ContentRangeHeader, CacheControlHeader, "authorization", "set-cookie":
+		return true
+	}
+	return false
+}
+
+// declaredTrailers returns the field names listed in the Trailer header.
+func declaredTrailers(h Header) []string {
+	var names []string
+	for _, value := range h.Values(TrailerHeader) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !forbiddenTrailer(name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func CanonicalHeaderKey(name string) string {
+	return textproto.CanonicalMIMEHeaderKey(name)
+}
+
+// Get returns the first value for name, or "" if there is none.
+func (h Header) Get(name string) string {
+	for _, field := range h {
+		if strings.EqualFold(field.Name, name) {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+func (h Header) Has(name string) bool {
+	for _, field := range h {
+		if strings.EqualFold(field.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Values returns all values for name in wire order.
+func (h Header) Values(name string) []string {
+	var values []string
+	for _, field := range h {
+		if strings.EqualFold(field.Name, name) {
+			values = append(values, field.Value)
+		}
+	}
+	return values
+}
+
+func (h *Header) Add(name, value string) {
+	*h = append(*h, HeaderField{Name: name, Value: value})
+}
+
+// Set replaces all values for name with value, keeping the position of the
+// first existing field. Like Del, it builds a new list instead of rewriting
+// the fields in place, so a Header shared by several responses isn't changed
+// through another copy of it.
+func (h *Header) Set(name, value string) {
+	fields := make(Header, 0, len(*h)+1)
+	found := false
+
+	for _, field := range *h {
+		if strings.EqualFold(field.Name, name) {
+			if found {
+				continue
+			}
+			found = true
+			field.Value = value
+		}
+		fields = append(fields, field)
+	}
+
+	if !found {
+		fields = append(fields, HeaderField{Name: name, Value: value})
+	}
+
+	*h = fields
+}
+
+func (h *Header) Del(name string) {
+	if !h.Has(name) {
+		return
+	}
+
+	fields := make(Header, 0, len(*h)-1)
+	for _, field := range *h {
+		if !strings.EqualFold(field.Name, name) {
+			fields = append(fields, field)
+		}
+	}
+	*h = fields
+}
+
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	return append(Header(nil), h...)
+}
@@ -0,0 +1,156 @@
+package httpx
+
+import (
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeaderOperations(t *testing.T) {
+	h := NewHeader("Content-Type", "text/plain", "X-Forwarded-For", "10.0.0.1")
+	h.Add("x-forwarded-for", "10.0.0.2")
+	h.Add("Accept", "text/html")
+
+	if got := h.Get("CONTENT-TYPE"); got != "text/plain" {
+		t.Errorf("Expected case-insensitive Get, got %q", got)
+	}
+	if got := h.Values("X-Forwarded-For"); len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "10.0.0.2" {
+		t.Errorf("Expected both forwarded values in order, got %v", got)
+	}
+
+	h.Set("x-forwarded-for", "10.0.0.3")
+	if got := h.Values("X-Forwarded-For"); len(got) != 1 || got[0] != "10.0.0.3" {
+		t.Errorf("Expected Set to replace all values, got %v", got)
+	}
+	if h[1].Value != "10.0.0.3" {
+		t.Errorf("Expected Set to keep the original position, got %v", h)
+	}
+
+	h.Del("content-type")
+	if h.Has("Content-Type") || h.Get("Content-Type") != "" {
+		t.Errorf("Expected Content-Type to be deleted, got %v", h)
+	}
+	if len(h) != 2 || h[1].Name != "Accept" {
+		t.Errorf("Expected remaining fields in order, got %v", h)
+	}
+
+	var empty Header
+	if empty.Get("anything") != "" || empty.Values("anything") != nil {
+		t.Errorf("Expected zero Header to be usable")
+	}
+	empty.Set("Host", "localhost")
+	if empty.Get("host") != "localhost" {
+		t.Errorf("Expected Set on zero Header to append, got %v", empty)
+	}
+}
+
+func TestRepeatedHeaders(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		if cookies := req.Headers.Values("Cookie"); len(cookies) != 2 || cookies[0] != "a=1" || cookies[1] != "b=2" {
+			t.Errorf("Expected both cookie headers in order, got %v", cookies)
+		}
+
+		headers := NewHeader("content-type", "text/plain")
+		headers.Add("set-cookie", "a=1")
+		headers.Add("set-cookie", "b=2")
+
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    headers,
+			Body:       strings.NewReader("ok"),
+		}
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	request := "GET / HTTP/1.1\r\nHost: localhost\r\nCookie: a=1\r\ncookie: b=2\r\nConnection: close\r\n\r\n"
+	response := makeRequest(t, addr, request)
+
+	first := strings.Index(response, "Set-Cookie: a=1\r\n")
+	second := strings.Index(response, "Set-Cookie: b=2\r\n")
+	if first == -1 || second == -1 || second < first {
+		t.Errorf("Expected two Set-Cookie lines in order, got: %s", response)
+	}
+	if !strings.Contains(response, "Content-Type: text/plain\r\n") {
+		t.Errorf("Expected canonical header casing, got: %s", response)
+	}
+}
+
+func TestSetAndDelLeaveCopiesAlone(t *testing.T) {
+	shared := NewHeader("Content-Type", "text/plain", "Content-Length", "5", "ETag", `"v1"`)
+	want := shared.Clone()
+
+	h := shared
+	h.Set("etag", `W/"v1"`)
+	h.Del("content-length")
+
+	if !slices.Equal(shared, want) {
+		t.Errorf("Expected the shared header to stay %v, got %v", want, shared)
+	}
+	if len(h) != 2 || h.Get("ETag") != `W/"v1"` {
+		t.Errorf("Expected the copy to be changed, got %v", h)
+	}
+}
+
+// TestSharedHeaderAcrossConnections is meant to be run with -race.
+func TestSharedHeaderAcrossConnections(t *testing.T) {
+	body := strings.Repeat("shared header ", 200)
+	shared := NewHeader(ContentTypeHeader, "text/plain", ContentLengthHeader, strconv.Itoa(len(body)),
+		ETagHeader, `"v1"`, AcceptRangesHeader, "bytes")
+	want := shared.Clone()
+
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		if req.Path == "/stream" {
+			*w.Header() = shared
+			io.WriteString(w, body)
+			return
+		}
+		HandlerFunc(func(req *HTTPRequest) *HTTPResponse {
+			return &HTTPResponse{StatusCode: 200, Headers: shared, Body: strings.NewReader(body)}
+		}).Stream()(w, req)
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, Chain(handler,
+		Compression(CompressionConfig{}), Conditional(ConditionalConfig{})))
+	defer cleanup()
+
+	requests := []string{
+		"GET /stream HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n",
+		"GET /stream HTTP/1.1\r\nHost: localhost\r\nIf-None-Match: \"v1\"\r\nConnection: close\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\nRange: bytes=0-1, 4-5\r\nConnection: close\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n",
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, request := range requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Errorf("Failed to connect: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(2 * time.Second))
+				conn.Write([]byte(request))
+				io.ReadAll(conn)
+			}()
+		}
+	}
+	wg.Wait()
+
+	if !slices.Equal(shared, want) {
+		t.Errorf("Expected the shared header to stay %v, got %v", want, shared)
+	}
+}
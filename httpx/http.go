@@ -3,43 +3,95 @@ package httpx
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var ErrServerClosed = errors.New("httpx: server closed")
+
 type HTTPRequest struct {
-	Method  string
-	Path    string
-	Version string
-	Headers map[string]string
+	Method     string
+	RequestURI string
+	Path       string
+	RawQuery   string
+	Host       string
+	Version    string
+	Headers    Header
 
 	Body      io.Reader
 	BodySize  int64
 	IsChunked bool
+
+	// Trailer is set from the trailer section of a chunked body once Body
+	// has returned io.EOF.
+	Trailer Header
+
+	Params map[string]string
+
+	query      url.Values
+	body       *maxBytesReader
+	expect     *expectContinueReader
+	reader     *bufio.Reader
+	closeAfter bool
+	session    *Session
+	forms      *formState
+}
+
+// Param returns the path parameter captured by the router under name.
+func (r *HTTPRequest) Param(name string) string {
+	return r.Params[name]
 }
 
 type HTTPResponse struct {
 	StatusCode int
 	StatusText string
-	Headers    map[string]string
+	Headers    Header
 	Body       io.Reader
-	bodySize   int64
-	version    string
+
+	// Trailer holds the values of the fields declared in the Trailer header.
+	// It is read after Body returns EOF, so it may be filled while streaming.
+	Trailer Header
+
+	// ContentLength is the length of Body. -1 means unknown, in which case
+	// the body is streamed chunked on HTTP/1.1 and delimited by closing the
+	// connection on HTTP/1.0. 0 with a non-nil Body means the length is taken
+	// from the body when it is a *bytes.Reader, *strings.Reader,
+	// *io.LimitedReader or a seeker such as *os.File, and unknown otherwise.
+	ContentLength int64
+
+	// closeBody marks a Body opened by the package, such as a file of
+	// FileServer, to be closed once it has been written.
+	closeBody bool
 }
 
 type HandlerFunc func(*HTTPRequest) *HTTPResponse
 
+func textResponse(statusCode int, text string) *HTTPResponse {
+	return &HTTPResponse{
+		StatusCode: statusCode,
+		StatusText: http.StatusText(statusCode),
+		Headers:    NewHeader(ContentTypeHeader, "text/plain"),
+		Body:       strings.NewReader(text),
+	}
+}
+
 type HTTPServer struct {
 	addr string
 	port string
 
 	maxRequestSize int64
 	maxHeaderSize  int64
+	maxDrainSize   int64
 
 	readTimeout  time.Duration
 	writeTimeout time.Duration
@@ -47,20 +99,84 @@ type HTTPServer struct {
 	keepAliveTimeout     time.Duration
 	maxKeepAliveRequests int
 	enableKeepAlive      bool
+	pipelineDepth        int
+
+	lenientParsing   bool
+	socketActivation bool
 
-	Handler HandlerFunc
+	decompressRequests bool
+
+	serverName        string
+	disableDate       bool
+	disableStatusText bool
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]connState
+	inShutdown atomic.Bool
+
+	Handler       HandlerFunc
+	StreamHandler StreamHandlerFunc
+
+	// RequestSizeLimit, when set, may return a per-request body limit that
+	// overrides MaxRequestSize. Zero or negative values keep the default.
+	RequestSizeLimit func(*HTTPRequest) int64
 }
 
+type connState int
+
+const (
+	stateIdle connState = iota
+	stateActive
+)
+
 type HTTPServerConfig struct {
 	Addr                 string
 	Port                 string
 	MaxRequestSize       int64
 	MaxHeaderSize        int64
+	MaxDrainSize         int64
 	ReadTimeout          time.Duration
 	WriteTimeout         time.Duration
 	KeepAliveTimeout     time.Duration
 	MaxKeepAliveRequests int
 	EnableKeepAlive      bool
+
+	// PipelineDepth is how many pipelined requests without a body may be
+	// handled concurrently on one connection. Responses are always written in
+	// request order. 0 or 1 handles pipelined requests one at a time.
+	PipelineDepth int
+
+	// LenientParsing accepts requests from legacy clients that strict
+	// RFC 9112 parsing rejects: bare LF line endings, obsolete line folding,
+	// whitespace before the header colon and requests carrying both
+	// Content-Length and Transfer-Encoding (the connection is closed after
+	// those). Conflicting or negative Content-Length values are always
+	// rejected.
+	LenientParsing bool
+
+	// SocketActivation serves on listeners inherited from the service
+	// manager (LISTEN_FDS) instead of binding Addr and Port.
+	SocketActivation bool
+
+	// DecompressRequests transparently decodes request bodies sent with a
+	// gzip or deflate Content-Encoding. The decoded body is limited to
+	// MaxRequestSize (or the route's override) like the encoded one, and
+	// other codings are refused with 415 Unsupported Media Type. BodySize
+	// keeps the encoded length.
+	DecompressRequests bool
+
+	// ServerName is sent in the Server header of every response unless the
+	// handler sets its own. Empty sends no Server header.
+	ServerName string
+
+	// DisableDateHeader stops the server from adding a Date header to
+	// responses that don't carry one.
+	DisableDateHeader bool
+
+	// DisableDefaultStatusText leaves the reason phrase empty when a handler
+	// doesn't provide one, instead of using the standard text for the code.
+	DisableDefaultStatusText bool
 }
 
 func NewHTTPServer(cfg HTTPServerConfig) *HTTPServer {
@@ -70,6 +186,9 @@ func NewHTTPServer(cfg HTTPServerConfig) *HTTPServer {
 	if cfg.MaxHeaderSize == 0 {
 		cfg.MaxHeaderSize = DefaultMaxHeaderSize
 	}
+	if cfg.MaxDrainSize == 0 {
+		cfg.MaxDrainSize = DefaultMaxDrainSize
+	}
 	if cfg.ReadTimeout == 0 {
 		cfg.ReadTimeout = 30 * time.Second
 	}
@@ -88,225 +207,276 @@ func NewHTTPServer(cfg HTTPServerConfig) *HTTPServer {
 		port:                 cfg.Port,
 		maxRequestSize:       cfg.MaxRequestSize,
 		maxHeaderSize:        cfg.MaxHeaderSize,
+		maxDrainSize:         cfg.MaxDrainSize,
 		readTimeout:          cfg.ReadTimeout,
 		writeTimeout:         cfg.WriteTimeout,
 		keepAliveTimeout:     cfg.KeepAliveTimeout,
 		maxKeepAliveRequests: cfg.MaxKeepAliveRequests,
 		enableKeepAlive:      cfg.EnableKeepAlive,
+		pipelineDepth:        cfg.PipelineDepth,
+		lenientParsing:       cfg.LenientParsing,
+		socketActivation:     cfg.SocketActivation,
+		decompressRequests:   cfg.DecompressRequests,
+		serverName:           cfg.ServerName,
+		disableDate:          cfg.DisableDateHeader,
+		disableStatusText:    cfg.DisableDefaultStatusText,
 	}
 }
 
-func (s *HTTPServer) parseRequest(conn net.Conn) (*HTTPRequest, error) {
-	var headerBuf bytes.Buffer
-
-	reader := bufio.NewReader(conn)
+func (s *HTTPServer) parseRequest(reader *bufio.Reader) (*HTTPRequest, error) {
+	var lines []string
+	headerBudget := int(s.maxHeaderSize)
 
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := readLine(reader, headerBudget, s.lenientParsing)
+		if err == errLineTooLong {
+			return nil, badRequest("headers too large")
+		}
 		if err != nil {
+			if _, ok := err.(*badRequestError); ok {
+				return nil, err
+			}
 			return nil, fmt.Errorf("error reading headers: %v", err)
 		}
 
-		headerBuf.Write(line)
+		headerBudget -= len(line) + 2
 
-		if bytes.Equal(line, []byte("\r\n")) {
+		if line == "" {
+			// empty lines before the request-line are ignored (RFC 9112 2.2)
+			if len(lines) == 0 {
+				continue
+			}
 			break
 		}
 
-		if headerBuf.Len() > int(s.maxHeaderSize) {
-			return nil, fmt.Errorf("headers too large")
-		}
+		lines = append(lines, line)
 	}
 
-	headerData := headerBuf.Bytes()
-	lines := bytes.Split(headerData, []byte("\r\n"))
-
-	if len(lines) < 1 {
-		return nil, fmt.Errorf("invalid request format")
-	}
-
-	requestLine := strings.Fields(string(lines[0]))
-	if len(requestLine) != 3 {
-		return nil, fmt.Errorf("invalid request line")
+	method, target, version, err := s.parseRequestLine(lines[0])
+	if err != nil {
+		return nil, err
 	}
 
 	req := &HTTPRequest{
-		Method:   requestLine[0],
-		Path:     requestLine[1],
-		Version:  requestLine[2],
-		Headers:  make(map[string]string),
-		BodySize: -1,
-	}
-
-	for i := 1; i < len(lines)-1; i++ {
-		line := strings.TrimSpace(string(lines[i]))
-		if line == "" {
+		Method:     method,
+		RequestURI: target,
+		Version:    version,
+		BodySize:   -1,
+		reader:     reader,
+		forms:      &formState{},
+	}
+
+	for _, line := range lines[1:] {
+		if line[0] == ' ' || line[0] == '\t' {
+			if !s.lenientParsing || len(req.Headers) == 0 {
+				return nil, badRequest("obsolete line folding")
+			}
+			last := &req.Headers[len(req.Headers)-1]
+			last.Value += " " + strings.Trim(line, " \t")
 			continue
 		}
 
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) == 2 {
-			key := strings.ToLower(strings.TrimSpace(parts[0]))
-			value := strings.TrimSpace(parts[1])
-			req.Headers[key] = value
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, badRequest("malformed header line %q", line)
 		}
-	}
 
-	if contentLength, exists := req.Headers[ContentLengthHeader]; exists {
-		if length, err := strconv.ParseInt(contentLength, 10, 64); err == nil {
-			req.BodySize = length
+		if trimmed := strings.TrimRight(key, " \t"); trimmed != key {
+			if !s.lenientParsing {
+				return nil, badRequest("whitespace before colon in header %q", trimmed)
+			}
+			key = trimmed
+		}
 
-			req.Body = io.LimitReader(reader, length)
-		} else {
-			return nil, fmt.Errorf("invalid content-length: %s", contentLength)
+		if !validHeaderName(key) {
+			return nil, badRequest("invalid header name %q", key)
 		}
-	} else if transferEncoding, exists := req.Headers[TransferEncodingHeader]; exists &&
-		strings.ToLower(transferEncoding) == "chunked" {
-		req.IsChunked = true
-		req.Body = newChunkedReader(reader)
-	} else {
-		req.Body = &emptyReader{}
-		req.BodySize = 0
-	}
 
-	return req, nil
-}
+		value = strings.Trim(value, " \t")
+		if !s.lenientParsing && !validHeaderValue(value) {
+			return nil, badRequest("invalid value for header %q", key)
+		}
 
-func (r *HTTPResponse) writeToConnection(conn net.Conn) error {
-	statusLine := fmt.Sprintf("%s %d %s\r\n", r.version, r.StatusCode, r.StatusText)
-	if _, err := conn.Write([]byte(statusLine)); err != nil {
-		return fmt.Errorf("error writing status line: %v", err)
+		req.Headers.Add(key, value)
 	}
 
-	if r.Headers == nil {
-		r.Headers = make(map[string]string)
+	if !s.lenientParsing && req.Version == HTTP11Version && len(req.Headers.Values(HostHeader)) != 1 {
+		return nil, badRequest("HTTP/1.1 request needs exactly one host header")
 	}
 
-	useChunked := r.bodySize < 0 && r.Body != nil
+	if err := req.parseTarget(req.RequestURI); err != nil {
+		return nil, badRequest("invalid request target %q: %v", req.RequestURI, err)
+	}
 
-	if useChunked {
-		r.Headers[TransferEncodingHeader] = "chunked"
-	} else if r.bodySize >= 0 {
-		r.Headers[ContentLengthHeader] = strconv.FormatInt(r.bodySize, 10)
+	if err := s.frameBody(req, reader); err != nil {
+		return nil, err
 	}
 
-	for key, value := range r.Headers {
-		headerLine := fmt.Sprintf("%s: %s\r\n", key, value)
-		if _, err := conn.Write([]byte(headerLine)); err != nil {
-			return fmt.Errorf("error writing header: %v", err)
-		}
+	return req, nil
+}
+
+func (s *HTTPServer) parseRequestLine(line string) (method, target, version string, err error) {
+	var requestLine []string
+	if s.lenientParsing {
+		requestLine = strings.Fields(line)
+	} else {
+		requestLine = strings.Split(line, " ")
 	}
 
-	if _, err := conn.Write([]byte("\r\n")); err != nil {
-		return fmt.Errorf("error writing header terminator: %v", err)
+	if len(requestLine) != 3 {
+		return "", "", "", badRequest("invalid request line")
 	}
 
-	if r.Body != nil {
-		if useChunked {
-			err := r.writeChunkedBody(conn)
-			if err != nil {
-				return fmt.Errorf("error writing chunked body: %v", err)
-			}
-		} else {
-			// direct copy for fixed-length body
-			_, err := io.Copy(conn, r.Body)
-			if err != nil {
-				return fmt.Errorf("error streaming body: %v", err)
-			}
+	method, target, version = requestLine[0], requestLine[1], requestLine[2]
+
+	if !validToken(method) || target == "" {
+		return "", "", "", badRequest("invalid request line")
+	}
+	if version != HTTP10Version && version != HTTP11Version {
+		if !validVersion(version) {
+			return "", "", "", badRequest("invalid protocol version %q", version)
+		}
+		return "", "", "", &badRequestError{
+			reason:     fmt.Sprintf("unsupported protocol version %q", version),
+			statusCode: http.StatusHTTPVersionNotSupported,
 		}
 	}
 
-	return nil
+	return method, target, version, nil
 }
 
-func (r *HTTPResponse) writeChunkedBody(conn net.Conn) error {
-	buffer := make([]byte, DefaultChunkSize)
+// frameBody decides how the request body is delimited, rejecting the
+// ambiguous framings that enable request smuggling (RFC 9112 6.3).
+func (s *HTTPServer) frameBody(req *HTTPRequest, reader *bufio.Reader) error {
+	transferEncodings := req.Headers.Values(TransferEncodingHeader)
+	contentLengths := req.Headers.Values(ContentLengthHeader)
 
-	for {
-		n, err := r.Body.Read(buffer)
-		if n > 0 {
-			chunkSize := fmt.Sprintf("%x\r\n", n)
-			if _, writeErr := conn.Write([]byte(chunkSize)); writeErr != nil {
-				return writeErr
+	if len(transferEncodings) > 0 {
+		if len(contentLengths) > 0 {
+			if !s.lenientParsing {
+				return badRequest("both content-length and transfer-encoding present")
 			}
+			// transfer-encoding overrides content-length, but the
+			// connection can't be trusted afterwards
+			req.Headers.Del(ContentLengthHeader)
+			req.closeAfter = true
+		}
 
-			if _, writeErr := conn.Write(buffer[:n]); writeErr != nil {
-				return writeErr
+		var codings []string
+		for _, value := range transferEncodings {
+			for _, coding := range strings.Split(value, ",") {
+				codings = append(codings, strings.ToLower(strings.Trim(coding, " \t")))
 			}
+		}
+		if len(codings) != 1 || codings[0] != "chunked" {
+			return badRequest("unsupported transfer-encoding %q", strings.Join(transferEncodings, ", "))
+		}
 
-			if _, writeErr := conn.Write([]byte("\r\n")); writeErr != nil {
-				return writeErr
+		// an HTTP/1.0 message can't be chunked, so its framing is faulty
+		// (RFC 9112 6.1)
+		if req.Version == HTTP10Version {
+			if !s.lenientParsing {
+				return badRequest("transfer-encoding in an HTTP/1.0 request")
 			}
+			req.closeAfter = true
 		}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
+		req.IsChunked = true
+		chunked := newChunkedReader(reader, s.lenientParsing)
+		chunked.trailer = &req.Trailer
+		chunked.trailerSize = int(s.maxHeaderSize)
+		req.Body = chunked
+		return nil
 	}
 
-	// final size 0 chunk
-	if _, err := conn.Write([]byte("0\r\n\r\n")); err != nil {
-		return err
+	if len(contentLengths) > 0 {
+		length, err := parseContentLength(contentLengths)
+		if err != nil {
+			return badRequest("%v", err)
+		}
+
+		req.BodySize = length
+		req.Body = io.LimitReader(reader, length)
+		return nil
 	}
 
+	req.Body = &emptyReader{}
+	req.BodySize = 0
 	return nil
 }
 
-func (res *HTTPResponse) getContentLength() {
+// bodyLength resolves ContentLength: -1 stays unknown, 0 with a body is
+// detected from the body type, anything else is taken as given.
+func (res *HTTPResponse) bodyLength() int64 {
 	if res.Body == nil {
-		return
+		return 0
+	}
+	if res.ContentLength != 0 {
+		return res.ContentLength
 	}
+	return readerLength(res.Body)
+}
 
-	if seeker, ok := res.Body.(io.Seeker); ok {
-		currentPos, err := seeker.Seek(0, io.SeekCurrent)
+// readerLength returns how many bytes are left in r, or -1 when that can't be
+// known without reading it.
+func readerLength(r io.Reader) int64 {
+	switch body := r.(type) {
+	case *bytes.Reader:
+		return int64(body.Len())
+	case *strings.Reader:
+		return int64(body.Len())
+	case *bytes.Buffer:
+		return int64(body.Len())
+	case *io.LimitedReader:
+		// N is only an upper bound unless the wrapped reader is shorter
+		if n := readerLength(body.R); n >= 0 {
+			return min(n, max(body.N, 0))
+		}
+		return -1
+	case io.Seeker:
+		currentPos, err := body.Seek(0, io.SeekCurrent)
 		if err != nil {
-			res.bodySize = -1
-			return
+			return -1
 		}
 
-		size, err := seeker.Seek(0, io.SeekEnd)
+		size, err := body.Seek(0, io.SeekEnd)
 		if err != nil {
-			res.bodySize = -1
-			return
+			return -1
 		}
 
-		_, err = seeker.Seek(currentPos, io.SeekStart)
-		if err != nil {
+		if _, err := body.Seek(currentPos, io.SeekStart); err != nil {
 			fmt.Println("Error seeking to original position:", err)
-			res.bodySize = -1
-			return
+			return -1
 		}
 
-		res.bodySize = size - currentPos
-		return
+		return size - currentPos
 	}
 
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		fmt.Println("Error reading body:", err)
-		res.bodySize = -1
-		return
-	}
+	return -1
+}
 
-	res.bodySize = int64(len(data))
-	res.Body = bytes.NewReader(data)
+func (s *HTTPServer) requestSizeLimit(req *HTTPRequest) int64 {
+	if s.RequestSizeLimit != nil {
+		if limit := s.RequestSizeLimit(req); limit > 0 {
+			return limit
+		}
+	}
+	return s.maxRequestSize
 }
 
-func (s *HTTPServer) shouldKeepConnectionAlive(req *HTTPRequest, res *HTTPResponse) bool {
-	if !s.enableKeepAlive {
+func (s *HTTPServer) shouldKeepConnectionAlive(req *HTTPRequest) bool {
+	if !s.enableKeepAlive || s.shuttingDown() || req.closeAfter {
 		return false
 	}
 
 	if req.Version == HTTP11Version {
-		if connHeader, exists := req.Headers[ConnectionHeader]; exists {
-			return strings.ToLower(connHeader) != "close"
+		if req.Headers.Has(ConnectionHeader) {
+			return strings.ToLower(req.Headers.Get(ConnectionHeader)) != "close"
 		}
 		return true
 	} else if req.Version == HTTP10Version {
-		if connHeader, exists := req.Headers[ConnectionHeader]; exists {
-			return strings.ToLower(connHeader) == "keep-alive"
+		if req.Headers.Has(ConnectionHeader) {
+			return strings.ToLower(req.Headers.Get(ConnectionHeader)) == "keep-alive"
 		}
 		return false
 	}
@@ -315,10 +485,36 @@ func (s *HTTPServer) shouldKeepConnectionAlive(req *HTTPRequest, res *HTTPRespon
 }
 
 func (s *HTTPServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+	if !s.trackConn(conn, true) {
+		conn.Close()
+		return
+	}
+
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+			s.trackConn(conn, false)
+		}
+	}()
 
 	fmt.Println("Client connected:", conn.RemoteAddr())
 
+	// one reader per connection so bytes buffered past the current request
+	// are kept for the next one
+	reader := bufio.NewReader(conn)
+
+	// responses are buffered and only flushed once no further pipelined
+	// request is waiting, so back-to-back responses share a write
+	bw := newBufferedWriter(conn)
+	pipe := &pipeline{conn: conn, out: bw, depth: s.pipelineDepth, writeTimeout: s.writeTimeout}
+	defer func() {
+		if !hijacked {
+			pipe.flush()
+		}
+		putBufferedWriter(bw)
+	}()
+
 	requestCount := 0
 	startTime := time.Now()
 
@@ -335,60 +531,147 @@ func (s *HTTPServer) handleConnection(conn net.Conn) {
 			}
 		}
 
+		if s.shuttingDown() {
+			break
+		}
+
+		// only parse ahead while the next request is already buffered,
+		// otherwise answer everything in flight before blocking on a read
+		if reader.Buffered() == 0 && !pipe.flush() {
+			break
+		}
+
+		// a connection only counts as idle once every response has been sent
+		if pipe.empty() && bw.Buffered() == 0 {
+			s.setConnState(conn, stateIdle)
+			if s.shuttingDown() {
+				break
+			}
+		}
+
 		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
 
-		request, err := s.parseRequest(conn)
+		request, err := s.parseRequest(reader)
 		if err != nil {
-			if s.enableKeepAlive && requestCount > 0 {
+			if s.shuttingDown() || !pipe.flush() {
+				break
+			}
+			if _, malformed := err.(*badRequestError); !malformed && requestCount > 0 {
 				fmt.Printf("Connection closed by client %s after %d requests\n", conn.RemoteAddr(), requestCount)
 				break
 			}
 			fmt.Printf("Error parsing request: %v\n", err)
-			s.sendErrorResponse(conn, http.StatusBadRequest, "Bad Request", false)
+			statusCode := http.StatusBadRequest
+			if malformed, ok := err.(*badRequestError); ok && malformed.statusCode != 0 {
+				statusCode = malformed.statusCode
+			}
+			s.sendErrorResponse(conn, bw, statusCode, http.StatusText(statusCode), false)
 			break
 		}
 
+		s.setConnState(conn, stateActive)
 		requestCount++
 
-		if s.Handler == nil {
-			s.sendErrorResponse(conn, http.StatusInternalServerError, "No handler defined", false)
-			break
+		handler := s.StreamHandler
+		if handler == nil && s.Handler != nil {
+			handler = s.Handler.Stream()
 		}
 
-		response := s.Handler(request)
-		if response == nil {
-			s.sendErrorResponse(conn, http.StatusInternalServerError, "Handler returned nil", false)
+		if handler == nil {
+			if pipe.flush() {
+				s.sendErrorResponse(conn, bw, http.StatusInternalServerError, "No handler defined", false)
+			}
 			break
 		}
 
-		shouldKeepAlive := s.shouldKeepConnectionAlive(request, response)
+		expect := request.Headers.Get(ExpectHeader)
+		if expect != "" && !strings.EqualFold(expect, ContinueExpectation) {
+			fmt.Printf("Unsupported expectation: %s\n", expect)
+			if pipe.flush() {
+				s.sendErrorResponse(conn, bw, http.StatusExpectationFailed, "Expectation Failed", false)
+			}
+			break
+		}
 
-		response.version = request.Version
-		response.getContentLength()
+		limit := s.requestSizeLimit(request)
+		if request.BodySize > limit {
+			fmt.Printf("Request body of %d bytes exceeds limit of %d\n", request.BodySize, limit)
+			if pipe.flush() {
+				s.sendErrorResponse(conn, bw, http.StatusRequestEntityTooLarge, "Payload Too Large", false)
+			}
+			break
+		}
 
-		if shouldKeepAlive {
-			if response.Headers == nil {
-				response.Headers = make(map[string]string)
+		var codings []string
+		if s.decompressRequests && request.BodySize != 0 {
+			codings, err = contentCodings(request.Headers.Values(ContentEncodingHeader))
+			if err != nil {
+				fmt.Printf("Unsupported request body: %v\n", err)
+				if pipe.flush() {
+					s.sendErrorResponse(conn, bw, http.StatusUnsupportedMediaType, "Unsupported Media Type", false,
+						AcceptEncodingHeader, supportedRequestCodings)
+				}
+				break
 			}
-			response.Headers[ConnectionHeader] = KeepAliveHeader
-			response.Headers[KeepAliveHeader] = fmt.Sprintf("timeout=%d, max=%d",
-				int(s.keepAliveTimeout.Seconds()), s.maxKeepAliveRequests-requestCount)
-		} else {
-			if response.Headers == nil {
-				response.Headers = make(map[string]string)
+		}
+
+		keepAlive := s.shouldKeepConnectionAlive(request)
+		w := s.newResponse(conn, bw, request, keepAlive, s.maxKeepAliveRequests-requestCount)
+
+		request.body = newMaxBytesReader(request.Body, limit)
+		request.Body = request.body
+
+		// HTTP/1.0 clients can't expect 100-continue (RFC 9110 10.1.1)
+		if expect != "" && request.Version != HTTP10Version && request.BodySize != 0 {
+			request.expect = &expectContinueReader{reader: request.Body, w: w}
+			request.Body = request.expect
+		}
+
+		// the decoded body gets the same limit as the encoded one so a small
+		// upload can't expand into an arbitrarily large one
+		if len(codings) > 0 {
+			request.Body = newMaxBytesReader(newDecodingReader(request.Body, codings), limit)
+			request.Headers.Del(ContentEncodingHeader)
+			request.Headers.Del(ContentLengthHeader)
+		}
+
+		if s.pipelineDepth > 1 && keepAlive && request.BodySize == 0 && reader.Buffered() > 0 {
+			if pipe.full() && !pipe.flushOne() {
+				break
 			}
-			response.Headers[ConnectionHeader] = CloseHeader
+			pipe.dispatch(handler, w)
+			continue
+		}
+
+		if !pipe.flush() {
+			break
 		}
 
 		conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
 
-		err = response.writeToConnection(conn)
-		if err != nil {
+		handler(w, request)
+		request.removeTempFiles()
+
+		if w.hijacked {
+			hijacked = true
+			return
+		}
+
+		if err := w.finish(); err != nil {
 			fmt.Printf("Error writing response: %v\n", err)
 			break
 		}
 
-		if !shouldKeepAlive {
+		if !w.keepAlive {
+			break
+		}
+
+		// don't hold the response back while waiting for the rest of a body
+		if request.BodySize != 0 && !pipe.flush() {
+			break
+		}
+
+		if !s.drainBody(request) {
 			break
 		}
 
@@ -400,44 +683,93 @@ func (s *HTTPServer) handleConnection(conn net.Conn) {
 	}
 }
 
-func (s *HTTPServer) sendErrorResponse(conn net.Conn, statusCode int, statusText string, keepAlive bool) {
-	body := strings.NewReader(statusText)
-
-	headers := map[string]string{
-		ContentTypeHeader: "text/plain",
+// drainBody discards what the handler left unread of the request body so the
+// next request can be parsed. It reports false when more than maxDrainSize
+// bytes remain or the body is broken, and the connection has to be closed.
+func (s *HTTPServer) drainBody(req *HTTPRequest) bool {
+	body := req.Body
+	if req.body != nil {
+		// skip the rest of the body as sent, without decoding it
+		body = req.body
 	}
-
-	if keepAlive {
-		headers[ConnectionHeader] = KeepAliveHeader
-	} else {
-		headers[ConnectionHeader] = CloseHeader
+	if body == nil {
+		return true
 	}
 
-	response := &HTTPResponse{
-		version:    HTTP11Version,
-		StatusCode: statusCode,
-		StatusText: statusText,
-		Headers:    headers,
-		Body:       body,
-		bodySize:   int64(len(statusText)),
+	n, err := io.CopyN(io.Discard, body, s.maxDrainSize+1)
+	if err == io.EOF {
+		return true
 	}
+	if err == nil && n > s.maxDrainSize {
+		fmt.Printf("Unread request body larger than %d bytes, closing connection\n", s.maxDrainSize)
+	}
+
+	return false
+}
 
-	response.writeToConnection(conn)
+// sendErrorResponse answers with statusText as a plain text body. headers
+// holds extra name/value pairs for the response.
+func (s *HTTPServer) sendErrorResponse(conn net.Conn, bw *bufio.Writer, statusCode int, statusText string, keepAlive bool, headers ...string) {
+	w := s.newResponse(conn, bw, nil, keepAlive, 0)
+	w.headers = NewHeader(headers...)
+	w.headers.Set(ContentTypeHeader, "text/plain")
+	w.headers.Set(ContentLengthHeader, strconv.Itoa(len(statusText)))
+
+	w.writeHeader(statusCode, statusText)
+	w.Write([]byte(statusText))
+	w.finish()
 }
 
 func (s *HTTPServer) Start() error {
-	address := fmt.Sprintf("%s:%s", s.addr, s.port)
-	listener, err := net.Listen("tcp", address)
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	listeners, err := s.listen()
 	if err != nil {
 		return fmt.Errorf("failed to start server: %v", err)
 	}
+
+	if len(listeners) == 1 {
+		return s.Serve(listeners[0])
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(listener)
+	}
+
+	err = <-errs
+	for _, listener := range listeners {
+		listener.Close()
+	}
+
+	return err
+}
+
+// Serve accepts connections on listener and handles each in its own
+// goroutine. The listener is closed when Serve returns.
+func (s *HTTPServer) Serve(listener net.Listener) error {
+	if !s.trackListener(listener, true) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(listener, false)
 	defer listener.Close()
 
-	fmt.Printf("HTTP server listening on %s\n", address)
+	fmt.Printf("HTTP server listening on %s\n", listener.Addr())
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
 			fmt.Printf("Error accepting connection: %v\n", err)
 			continue
 		}
@@ -445,3 +777,124 @@ func (s *HTTPServer) Start() error {
 		go s.handleConnection(conn)
 	}
 }
+
+// Shutdown stops accepting new connections, closes idle keep-alive connections
+// and waits for active requests to finish. If ctx expires first, the remaining
+// connections are left open and ctx.Err() is returned.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections, including those
+// with requests in flight.
+func (s *HTTPServer) Close() error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.closeListenersLocked()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+
+	return err
+}
+
+func (s *HTTPServer) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+func (s *HTTPServer) trackListener(listener net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[listener] = struct{}{}
+	} else {
+		delete(s.listeners, listener)
+	}
+
+	return true
+}
+
+func (s *HTTPServer) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]connState)
+		}
+		s.conns[conn] = stateIdle
+	} else {
+		delete(s.conns, conn)
+	}
+
+	return true
+}
+
+func (s *HTTPServer) setConnState(conn net.Conn, state connState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.conns[conn]; exists {
+		s.conns[conn] = state
+	}
+}
+
+func (s *HTTPServer) closeListenersLocked() error {
+	var err error
+	for listener := range s.listeners {
+		if closeErr := listener.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(s.listeners, listener)
+	}
+	return err
+}
+
+// closeIdleConns closes every idle connection and reports whether
+// no connections remain.
+func (s *HTTPServer) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, state := range s.conns {
+		if state == stateIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+
+	return len(s.conns) == 0
+}
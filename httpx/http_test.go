@@ -3,15 +3,25 @@ package httpx
 import (
 	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func setupTestServer(t *testing.T, handler HandlerFunc) (*HTTPServer, string, func()) {
+func newTestServer() *HTTPServer {
 	config := HTTPServerConfig{
 		Addr:                 "localhost",
 		Port:                 "0",
@@ -24,9 +34,10 @@ func setupTestServer(t *testing.T, handler HandlerFunc) (*HTTPServer, string, fu
 		EnableKeepAlive:      true,
 	}
 
-	server := NewHTTPServer(config)
-	server.Handler = handler
+	return NewHTTPServer(config)
+}
 
+func startTestServer(t testing.TB, server *HTTPServer) (string, func()) {
 	listener, err := net.Listen("tcp", "localhost:0")
 	if err != nil {
 		t.Fatalf("Failed to create listener: %v", err)
@@ -34,32 +45,31 @@ func setupTestServer(t *testing.T, handler HandlerFunc) (*HTTPServer, string, fu
 
 	addr := listener.Addr().String()
 
-	done := make(chan bool)
-
-	go func() {
-		defer listener.Close()
-		for {
-			select {
-			case <-done:
-				return
-			default:
-				conn, err := listener.Accept()
-				if err != nil {
-					return
-				}
-				go server.handleConnection(conn)
-			}
-		}
-	}()
+	go server.Serve(listener)
 
 	cleanup := func() {
-		close(done)
 		listener.Close()
 		time.Sleep(10 * time.Millisecond)
 	}
 
 	time.Sleep(10 * time.Millisecond)
 
+	return addr, cleanup
+}
+
+func setupTestServer(t *testing.T, handler HandlerFunc) (*HTTPServer, string, func()) {
+	server := newTestServer()
+	server.Handler = handler
+
+	addr, cleanup := startTestServer(t, server)
+	return server, addr, cleanup
+}
+
+func setupStreamTestServer(t *testing.T, handler StreamHandlerFunc) (*HTTPServer, string, func()) {
+	server := newTestServer()
+	server.StreamHandler = handler
+
+	addr, cleanup := startTestServer(t, server)
 	return server, addr, cleanup
 }
 
@@ -117,7 +127,7 @@ func TestHTTP11BasicRequest(t *testing.T) {
 		return &HTTPResponse{
 			StatusCode: 200,
 			StatusText: "OK",
-			Headers:    map[string]string{"content-type": "text/plain"},
+			Headers:    Header{{"content-type", "text/plain"}},
 			Body:       strings.NewReader("Hello World"),
 		}
 	}
@@ -236,6 +246,70 @@ func TestChunkedTransferEncoding(t *testing.T) {
 	}
 }
 
+func TestChunkedRequestTrailers(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		if len(req.Trailer) != 0 {
+			t.Errorf("Expected no trailers before body is read, got: %v", req.Trailer)
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("Error reading chunked body: %v", err)
+		}
+
+		return textResponse(200, string(body)+" "+req.Trailer.Get("x-checksum"))
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	request := "POST /chunked HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Trailer: X-Checksum\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Connection: close\r\n\r\n" +
+		"5\r\nhello\r\n" +
+		"0\r\n" +
+		"X-Checksum: abc123\r\n" +
+		"Content-Length: 999\r\n\r\n"
+
+	response := makeRequest(t, addr, request)
+
+	if !strings.HasSuffix(response, "\r\n\r\nhello abc123") {
+		t.Errorf("Expected trailer value in body, got: %q", response)
+	}
+}
+
+func TestChunkedRequestForbiddenTrailerDropped(t *testing.T) {
+	var trailer Header
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		io.ReadAll(req.Body)
+		trailer = req.Trailer
+		return textResponse(200, "ok")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	request := "POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"Connection: close\r\n\r\n" +
+		"0\r\n" +
+		"Host: evil.example\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"X-Kept: yes\r\n\r\n"
+
+	makeRequest(t, addr, request)
+
+	if trailer.Has(HostHeader) || trailer.Has(TransferEncodingHeader) {
+		t.Errorf("Expected forbidden trailer fields to be dropped, got: %v", trailer)
+	}
+	if trailer.Get("x-kept") != "yes" {
+		t.Errorf("Expected X-Kept trailer, got: %v", trailer)
+	}
+}
+
 func TestKeepAliveHTTP11(t *testing.T) {
 	requestCount := 0
 
@@ -280,7 +354,10 @@ func TestKeepAliveHTTP11(t *testing.T) {
 		}
 	}
 
-	reader.ReadString('\n')
+	body1 := make([]byte, len("Request 1"))
+	if _, err := io.ReadFull(reader, body1); err != nil {
+		t.Fatalf("Failed to read first response body: %v", err)
+	}
 
 	request2 := "GET /test2 HTTP/1.1\r\nHost: localhost\r\n\r\n"
 	_, err = conn.Write([]byte(request2))
@@ -408,11 +485,11 @@ func TestChunkedResponseWriting(t *testing.T) {
 
 	handler := func(req *HTTPRequest) *HTTPResponse {
 		return &HTTPResponse{
-			StatusCode: 200,
-			StatusText: "OK",
-			Headers:    map[string]string{"content-type": "text/plain"},
-			Body:       strings.NewReader(largeBody),
-			bodySize:   -1,
+			StatusCode:    200,
+			StatusText:    "OK",
+			Headers:       Header{{"content-type", "text/plain"}},
+			Body:          strings.NewReader(largeBody),
+			ContentLength: -1,
 		}
 	}
 
@@ -471,22 +548,191 @@ func TestChunkedResponseWriting(t *testing.T) {
 	}
 }
 
+func TestUnknownLengthBodyIsStreamed(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK", Body: pr}
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	go pw.Write([]byte("partial"))
+
+	// the first chunk arrives while the body is still open
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	_, headers := readResponseHead(t, reader)
+
+	if headers[TransferEncodingHeader] != "chunked" {
+		t.Errorf("Expected chunked encoding for unknown length, got: %v", headers)
+	}
+
+	sizeLine, _ := reader.ReadString('\n')
+	chunk, _ := reader.ReadString('\n')
+	if sizeLine != "7\r\n" || chunk != "partial\r\n" {
+		t.Errorf("Expected first chunk before the body ended, got: %q %q", sizeLine, chunk)
+	}
+}
+
+func TestUnknownLengthBodyHTTP10(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       io.MultiReader(strings.NewReader("close "), strings.NewReader("delimited")),
+		}
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n")
+
+	if strings.Contains(strings.ToLower(response), ContentLengthHeader) {
+		t.Errorf("Expected no content-length for unknown length, got: %s", response)
+	}
+	if !strings.Contains(response, "Connection: close") || !strings.HasSuffix(response, "\r\n\r\nclose delimited") {
+		t.Errorf("Expected close-delimited body, got: %q", response)
+	}
+}
+
+func TestKnownLengthBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   io.Reader
+		length string
+	}{
+		{"bytes.Reader", bytes.NewReader([]byte("hello")), "5"},
+		{"strings.Reader", strings.NewReader("hello"), "5"},
+		{"LimitedReader", io.LimitReader(strings.NewReader("hello world"), 5), "5"},
+		{"short LimitedReader", io.LimitReader(strings.NewReader("hi"), 5), "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := func(req *HTTPRequest) *HTTPResponse {
+				return &HTTPResponse{StatusCode: 200, StatusText: "OK", Body: tt.body}
+			}
+
+			_, addr, cleanup := setupTestServer(t, handler)
+			defer cleanup()
+
+			response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+			if !strings.Contains(response, "Content-Length: "+tt.length+"\r\n") {
+				t.Errorf("Expected content-length %s, got: %s", tt.length, response)
+			}
+		})
+	}
+}
+
+func TestDateAndServerHeaders(t *testing.T) {
+	server := newTestServer()
+	server.serverName = "httpx-test"
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 201, Body: strings.NewReader("created")}
+	}
+
+	addr, cleanup := startTestServer(t, server)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	statusLine, headers := readResponseHead(t, bufio.NewReader(conn))
+
+	if statusLine != "HTTP/1.1 201 Created" {
+		t.Errorf("Expected default status text, got: %q", statusLine)
+	}
+	if headers[ServerHeader] != "httpx-test" {
+		t.Errorf("Expected Server header, got: %v", headers)
+	}
+
+	date, err := http.ParseTime(headers[DateHeader])
+	if err != nil {
+		t.Fatalf("Expected valid Date header, got %q: %v", headers[DateHeader], err)
+	}
+	if time.Since(date) > 5*time.Second {
+		t.Errorf("Expected current date, got: %v", date)
+	}
+}
+
+func TestDefaultHeadersCanBeDisabled(t *testing.T) {
+	server := NewHTTPServer(HTTPServerConfig{
+		EnableKeepAlive:          true,
+		DisableDateHeader:        true,
+		DisableDefaultStatusText: true,
+	})
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Body: strings.NewReader("ok")}
+	}
+
+	addr, cleanup := startTestServer(t, server)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasPrefix(response, "HTTP/1.1 200 \r\n") {
+		t.Errorf("Expected empty reason phrase, got: %q", response)
+	}
+	if strings.Contains(response, "Date:") || strings.Contains(response, "Server:") {
+		t.Errorf("Expected no Date or Server header, got: %q", response)
+	}
+}
+
+func TestHandlerDateHeaderIsKept(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(DateHeader, "Tue, 15 Nov 1994 08:12:31 GMT")
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if strings.Count(response, "Date:") != 1 || !strings.Contains(response, "Date: Tue, 15 Nov 1994 08:12:31 GMT") {
+		t.Errorf("Expected the handler's Date header only, got: %q", response)
+	}
+}
+
+func TestDateCacheUpdatesEverySecond(t *testing.T) {
+	var cache dateCache
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first := cache.get(now)
+	if cache.get(now.Add(500*time.Millisecond)) != first {
+		t.Errorf("Expected cached value within the same second")
+	}
+	if next := cache.get(now.Add(time.Second)); next != "Wed, 01 May 2024 12:00:01 GMT" {
+		t.Errorf("Expected value for the next second, got: %q", next)
+	}
+}
+
 func TestMultipleHeaders(t *testing.T) {
 	handler := func(req *HTTPRequest) *HTTPResponse {
-		if userAgent, exists := req.Headers["user-agent"]; !exists || userAgent != "TestClient/1.0" {
+		if userAgent := req.Headers.Get("user-agent"); userAgent != "TestClient/1.0" {
 			t.Errorf("Expected User-Agent header, got: %v", req.Headers)
 		}
-		if accept, exists := req.Headers["accept"]; !exists || accept != "text/html,application/json" {
+		if accept := req.Headers.Get("accept"); accept != "text/html,application/json" {
 			t.Errorf("Expected Accept header, got: %v", req.Headers)
 		}
 
 		return &HTTPResponse{
 			StatusCode: 200,
 			StatusText: "OK",
-			Headers: map[string]string{
-				"content-type":    "application/json",
-				"cache-control":   "no-cache",
-				"x-custom-header": "test-value",
+			Headers: Header{
+				{"content-type", "application/json"},
+				{"cache-control", "no-cache"},
+				{"x-custom-header", "test-value"},
 			},
 			Body: strings.NewReader(`{"status": "success"}`),
 		}
@@ -502,10 +748,10 @@ func TestMultipleHeaders(t *testing.T) {
 
 	response := makeRequest(t, addr, request)
 
-	if !strings.Contains(response, "content-type: application/json") {
+	if !strings.Contains(response, "Content-Type: application/json") {
 		t.Errorf("Expected content-type header in response, got: %s", response)
 	}
-	if !strings.Contains(response, "x-custom-header: test-value") {
+	if !strings.Contains(response, "X-Custom-Header: test-value") {
 		t.Errorf("Expected custom header in response, got: %s", response)
 	}
 }
@@ -541,31 +787,960 @@ func TestEmptyBodyRequest(t *testing.T) {
 	}
 }
 
-func BenchmarkHTTPServer(b *testing.B) {
+func TestRequestTargetParsing(t *testing.T) {
+	tests := []struct {
+		method   string
+		target   string
+		path     string
+		rawQuery string
+		host     string
+	}{
+		{"GET", "/api/status", "/api/status", "", "localhost"},
+		{"GET", "/api/status?x=1&y=2", "/api/status", "x=1&y=2", "localhost"},
+		{"GET", "/files/hello%20world.txt", "/files/hello world.txt", "", "localhost"},
+		{"GET", "http://example.com/path?q=go", "/path", "q=go", "example.com"},
+		{"GET", "http://example.com:8080", "/", "", "example.com:8080"},
+		{"GET", "https://example.com?q=1", "/", "q=1", "example.com"},
+		{"OPTIONS", "*", "*", "", "localhost"},
+	}
+
+	for _, tt := range tests {
+		req := &HTTPRequest{Method: tt.method, Headers: NewHeader(HostHeader, "localhost")}
+		if err := req.parseTarget(tt.target); err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.method, tt.target, err)
+			continue
+		}
+		if req.Path != tt.path || req.RawQuery != tt.rawQuery || req.Host != tt.host {
+			t.Errorf("%s %s: expected path=%q query=%q host=%q, got path=%q query=%q host=%q",
+				tt.method, tt.target, tt.path, tt.rawQuery, tt.host, req.Path, req.RawQuery, req.Host)
+		}
+	}
+}
+
+func TestInvalidRequestTargets(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+	}{
+		{"GET", "*"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "/search?q=%"},
+		{"GET", "relative/path"},
+		{"GET", "ftp://example.com/file"},
+		{"GET", "http:///no-host"},
+	}
+
+	for _, tt := range tests {
+		req := &HTTPRequest{Method: tt.method}
+		if err := req.parseTarget(tt.target); err == nil {
+			t.Errorf("%s %s: expected error, got path=%q", tt.method, tt.target, req.Path)
+		}
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
 	handler := func(req *HTTPRequest) *HTTPResponse {
-		return &HTTPResponse{
-			StatusCode: 200,
-			StatusText: "OK",
-			Body:       strings.NewReader("OK"),
+		if req.Path != "/search" {
+			t.Errorf("Expected path /search, got %s", req.Path)
+		}
+		if req.RequestURI != "/search?q=go+lang&tag=a&tag=b%26c" {
+			t.Errorf("Expected raw request URI to be preserved, got %s", req.RequestURI)
+		}
+
+		query := req.Query()
+		if query.Get("q") != "go lang" {
+			t.Errorf("Expected q=go lang, got %q", query.Get("q"))
+		}
+		if tags := query["tag"]; len(tags) != 2 || tags[0] != "a" || tags[1] != "b&c" {
+			t.Errorf("Expected tag=[a b&c], got %v", tags)
 		}
+
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK"}
 	}
 
-	_, addr, cleanup := setupTestServer(nil, handler)
+	_, addr, cleanup := setupTestServer(t, handler)
 	defer cleanup()
 
-	b.ResetTimer()
+	response := makeRequest(t, addr, "GET /search?q=go+lang&tag=a&tag=b%26c HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.Contains(response, "HTTP/1.1 200 OK") {
+		t.Errorf("Expected HTTP/1.1 200 OK, got: %s", response)
+	}
 
-	for i := 0; i < b.N; i++ {
-		conn, err := net.Dial("tcp", addr)
+	response = makeRequest(t, addr, "GET /search?q=%zz HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.Contains(response, "400") {
+		t.Errorf("Expected 400 for malformed escape, got: %s", response)
+	}
+}
+
+func TestQueryWithSemicolon(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, req.RawQuery+" c="+req.Query().Get("c"))
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET /x?a=1;b=2&c=3 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	if !strings.HasPrefix(response, "HTTP/1.1 200") || !strings.HasSuffix(response, "a=1;b=2&c=3 c=3") {
+		t.Errorf("Expected semicolons to be accepted, got: %q", response)
+	}
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	handlerCalled := false
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		handlerCalled = true
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK"}
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+	server.maxRequestSize = 10
+
+	request := "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world"
+	response := makeRequest(t, addr, request)
+
+	if !strings.Contains(response, "HTTP/1.1 413 Payload Too Large") {
+		t.Errorf("Expected 413 Payload Too Large, got: %s", response)
+	}
+	if handlerCalled {
+		t.Errorf("Expected handler not to be called for oversized body")
+	}
+}
+
+func TestChunkedRequestTooLarge(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		body, err := io.ReadAll(req.Body)
+
+		var maxErr *MaxBytesError
+		if !errors.As(err, &maxErr) || maxErr.Limit != 10 {
+			t.Errorf("Expected MaxBytesError with limit 10, got: %v", err)
+		}
+		if len(body) != 10 {
+			t.Errorf("Expected body to be cut at the limit, got %d bytes", len(body))
+		}
+
+		return textResponse(413, "Payload Too Large")
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+	server.maxRequestSize = 10
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\n12345678\r\n8\r\n12345678\r\n0\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to be closed after oversized body, got: %v", err)
+	}
+	if !strings.Contains(string(response), "413") || !strings.Contains(string(response), "Connection: close") {
+		t.Errorf("Expected 413 with Connection: close, got: %s", response)
+	}
+}
+
+func TestRouteRequestSizeOverride(t *testing.T) {
+	echo := func(req *HTTPRequest) *HTTPResponse {
+		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			b.Fatalf("Failed to connect: %v", err)
+			return textResponse(413, "Payload Too Large")
 		}
+		return textResponse(200, string(body))
+	}
 
-		request := "GET /bench HTTP/1.1\r\nHost: localhost\r\n\r\n"
-		conn.Write([]byte(request))
+	router := NewRouter()
+	router.Post("/small", echo)
+	router.Post("/large", echo).MaxRequestSize(100)
+	router.Post("/tiny", echo).MaxRequestSize(2)
 
-		response := make([]byte, 256)
-		conn.Read(response)
-		conn.Close()
+	server, addr, cleanup := setupTestServer(t, router.ServeRequest)
+	defer cleanup()
+	server.maxRequestSize = 10
+	server.RequestSizeLimit = router.RequestSizeLimit
+
+	tests := []struct {
+		path   string
+		body   string
+		status string
+	}{
+		{"/small", "0123456789", "200"},
+		{"/small", "0123456789a", "413"},
+		{"/large", strings.Repeat("x", 50), "200"},
+		{"/tiny", "abc", "413"},
+	}
+
+	for _, tt := range tests {
+		request := fmt.Sprintf("POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n%s",
+			tt.path, len(tt.body), tt.body)
+		response := makeRequest(t, addr, request)
+
+		if !strings.Contains(response, "HTTP/1.1 "+tt.status) {
+			t.Errorf("POST %s with %d bytes: expected %s, got: %s", tt.path, len(tt.body), tt.status, response)
+		}
+	}
+}
+
+func TestUnreadBodyIsDrained(t *testing.T) {
+	var paths []string
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		paths = append(paths, req.Path)
+		return textResponse(200, "ignored body of "+req.Path)
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	// both requests arrive in one write, so the second one is already
+	// buffered when the first is parsed
+	conn.Write([]byte("POST /first HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world" +
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+		"GET /third HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read responses: %v", err)
+	}
+
+	if count := strings.Count(string(response), "HTTP/1.1 200 OK"); count != 3 {
+		t.Errorf("Expected 3 responses, got %d: %s", count, response)
+	}
+	if strings.Join(paths, ",") != "/first,/second,/third" {
+		t.Errorf("Expected requests to be parsed in order, got %v", paths)
+	}
+}
+
+func TestLargeUnreadBodyClosesConnection(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, "not reading that")
 	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+	server.maxDrainSize = 4
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close, got: %v", err)
+	}
+	if !strings.Contains(string(response), "Connection: close") {
+		t.Errorf("Expected Connection: close for large unread body, got: %s", response)
+	}
+}
+
+func TestLargeUnreadChunkedBodyClosesConnection(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, "not reading that")
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+	server.maxDrainSize = 4
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"a\r\n0123456789\r\n0\r\n\r\n" +
+		"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close, got: %v", err)
+	}
+	if count := strings.Count(string(response), "HTTP/1.1 200 OK"); count != 1 {
+		t.Errorf("Expected only the first response before close, got %d: %s", count, response)
+	}
+}
+
+func readPipelinedBodies(t *testing.T, conn net.Conn, count int) []string {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var bodies []string
+	for i := 0; i < count; i++ {
+		statusLine, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read status line of response %d: %v", i+1, err)
+		}
+		if !strings.Contains(statusLine, "200 OK") {
+			t.Errorf("Expected 200 OK for response %d, got: %s", i+1, statusLine)
+		}
+
+		length := 0
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("Failed to read headers of response %d: %v", i+1, err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			if value, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+				length, _ = strconv.Atoi(strings.TrimSpace(value))
+			}
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			t.Fatalf("Failed to read body of response %d: %v", i+1, err)
+		}
+		bodies = append(bodies, string(body))
+	}
+
+	return bodies
+}
+
+func TestPipelinedRequestsInOrder(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, req.Path)
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /2 HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /3 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	bodies := readPipelinedBodies(t, conn, 3)
+	if strings.Join(bodies, ",") != "/1,/2,/3" {
+		t.Errorf("Expected responses in request order, got %v", bodies)
+	}
+}
+
+func TestPipelinedRequestsConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		// earlier requests finish last
+		delay, _ := strconv.Atoi(strings.TrimPrefix(req.Path, "/"))
+		time.Sleep(time.Duration(5-delay) * 20 * time.Millisecond)
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+
+		return textResponse(200, req.Path)
+	}
+
+	server := newTestServer()
+	server.Handler = handler
+	server.pipelineDepth = 4
+
+	addr, cleanup := startTestServer(t, server)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	var requests strings.Builder
+	for i := 1; i <= 4; i++ {
+		fmt.Fprintf(&requests, "GET /%d HTTP/1.1\r\nHost: localhost\r\n\r\n", i)
+	}
+	conn.Write([]byte(requests.String()))
+
+	bodies := readPipelinedBodies(t, conn, 4)
+	if strings.Join(bodies, ",") != "/1,/2,/3,/4" {
+		t.Errorf("Expected responses in request order, got %v", bodies)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive < 2 {
+		t.Errorf("Expected pipelined requests to be handled concurrently, max active was %d", maxActive)
+	}
+}
+
+func TestPipelinedRequestsStopAtClose(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		mu.Lock()
+		paths = append(paths, req.Path)
+		mu.Unlock()
+		return textResponse(200, req.Path)
+	}
+
+	server := newTestServer()
+	server.Handler = handler
+	server.pipelineDepth = 4
+
+	addr, cleanup := startTestServer(t, server)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /1 HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /2 HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n" +
+		"GET /3 HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close, got: %v", err)
+	}
+
+	if count := strings.Count(string(response), "HTTP/1.1 200 OK"); count != 2 {
+		t.Errorf("Expected 2 responses before close, got %d: %s", count, response)
+	}
+	if strings.Contains(string(response), "/3") {
+		t.Errorf("Expected no response after Connection: close, got: %s", response)
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("Error reading body: %v", err)
+		}
+		return textResponse(200, "got "+string(body))
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	interim, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read interim response: %v", err)
+	}
+	if interim != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("Expected 100 Continue before sending body, got: %q", interim)
+	}
+	reader.ReadString('\n')
+
+	conn.Write([]byte("hello"))
+
+	statusLine, headers := readResponseHead(t, reader)
+	if statusLine != "HTTP/1.1 200 OK" {
+		t.Errorf("Expected HTTP/1.1 200 OK, got: %s", statusLine)
+	}
+	if headers[ConnectionHeader] != KeepAliveHeader {
+		t.Errorf("Expected connection to stay open once the body was read, got: %v", headers)
+	}
+
+	body := make([]byte, len("got hello"))
+	io.ReadFull(reader, body)
+	if string(body) != "got hello" {
+		t.Errorf("Expected body to reach the handler, got: %q", body)
+	}
+}
+
+func TestExpectContinueRejectedEarly(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(401, "Unauthorized")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000000\r\nExpect: 100-continue\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close without waiting for the body, got: %v", err)
+	}
+
+	if strings.Contains(string(response), "100 Continue") {
+		t.Errorf("Expected no 100 Continue when the handler refuses the body, got: %s", response)
+	}
+	if !strings.HasPrefix(string(response), "HTTP/1.1 401 Unauthorized") ||
+		!strings.Contains(string(response), "Connection: close") {
+		t.Errorf("Expected 401 with Connection: close, got: %s", response)
+	}
+}
+
+func TestExpectContinueTooLarge(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		t.Errorf("Expected handler not to be called")
+		return textResponse(200, "OK")
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+	server.maxRequestSize = 10
+
+	response := makeRequest(t, addr,
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\nExpect: 100-continue\r\n\r\n")
+
+	if strings.Contains(response, "100 Continue") || !strings.Contains(response, "413") {
+		t.Errorf("Expected 413 without 100 Continue, got: %s", response)
+	}
+}
+
+func TestUnknownExpectation(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		t.Errorf("Expected handler not to be called")
+		return textResponse(200, "OK")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr,
+		"POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 200-ok\r\n\r\nhello")
+
+	if !strings.Contains(response, "HTTP/1.1 417 Expectation Failed") {
+		t.Errorf("Expected 417 Expectation Failed, got: %s", response)
+	}
+}
+
+func setupDecompressingServer(t *testing.T, handler HandlerFunc) (string, func()) {
+	server := newTestServer()
+	server.decompressRequests = true
+	server.Handler = handler
+
+	return startTestServer(t, server)
+}
+
+func compressBody(t *testing.T, coding string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+
+	switch coding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func echoBodyHandler(t *testing.T) HandlerFunc {
+	return func(req *HTTPRequest) *HTTPResponse {
+		if req.Headers.Has(ContentEncodingHeader) {
+			t.Errorf("Expected Content-Encoding to be removed, got: %v", req.Headers)
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return textResponse(400, err.Error())
+		}
+		return textResponse(200, string(body))
+	}
+}
+
+func TestRequestBodyDecompression(t *testing.T) {
+	payload := []byte(`{"agent": "a-17", "metrics": [1, 2, 3]}`)
+
+	tests := []struct {
+		name    string
+		coding  string
+		header  string
+		chunked bool
+	}{
+		{"gzip", "gzip", "gzip", false},
+		{"x-gzip chunked", "gzip", "x-gzip", true},
+		{"deflate", "deflate", "deflate", false},
+		{"raw deflate chunked", "raw-deflate", "deflate", true},
+	}
+
+	addr, cleanup := setupDecompressingServer(t, echoBodyHandler(t))
+	defer cleanup()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := compressBody(t, tt.coding, payload)
+
+			request := "POST /ingest HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n" +
+				"Content-Encoding: " + tt.header + "\r\n"
+			if tt.chunked {
+				request += fmt.Sprintf("Transfer-Encoding: chunked\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(body), body)
+			} else {
+				request += fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+			}
+
+			response := makeRequest(t, addr, request)
+
+			if !strings.HasSuffix(response, "\r\n\r\n"+string(payload)) {
+				t.Errorf("Expected decoded body, got: %q", response)
+			}
+		})
+	}
+}
+
+func TestRequestBodyDecompressionBomb(t *testing.T) {
+	body := compressBody(t, "gzip", make([]byte, 4*1024*1024))
+
+	server := newTestServer()
+	server.decompressRequests = true
+	server.maxRequestSize = 64 * 1024
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		_, err := io.ReadAll(req.Body)
+		if _, ok := err.(*MaxBytesError); !ok {
+			t.Errorf("Expected MaxBytesError for decoded body, got: %v", err)
+		}
+		return textResponse(413, "too large")
+	}
+
+	addr, cleanup := startTestServer(t, server)
+	defer cleanup()
+
+	if int64(len(body)) > server.maxRequestSize {
+		t.Fatalf("Compressed body of %d bytes should fit the limit", len(body))
+	}
+
+	response := makeRequest(t, addr, fmt.Sprintf(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", len(body), body))
+
+	if !strings.Contains(response, "HTTP/1.1 413") {
+		t.Errorf("Expected handler to refuse the expanded body, got: %s", response)
+	}
+}
+
+func TestRequestBodyUnsupportedEncoding(t *testing.T) {
+	addr, cleanup := setupDecompressingServer(t, func(req *HTTPRequest) *HTTPResponse {
+		t.Errorf("Expected handler not to be called")
+		return textResponse(200, "OK")
+	})
+	defer cleanup()
+
+	response := makeRequest(t, addr,
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: br\r\nContent-Length: 5\r\n\r\nhello")
+
+	if !strings.Contains(response, "HTTP/1.1 415 Unsupported Media Type") {
+		t.Errorf("Expected 415, got: %s", response)
+	}
+	if !strings.Contains(response, "Accept-Encoding: gzip, deflate") {
+		t.Errorf("Expected supported codings to be advertised, got: %s", response)
+	}
+}
+
+func TestRequestBodyDecompressionIsOptIn(t *testing.T) {
+	body := compressBody(t, "gzip", []byte("still compressed"))
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		raw, _ := io.ReadAll(req.Body)
+		if !bytes.Equal(raw, body) || req.Headers.Get(ContentEncodingHeader) != "gzip" {
+			t.Errorf("Expected body and headers untouched, got %d bytes, %v", len(raw), req.Headers)
+		}
+		return textResponse(200, "OK")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	makeRequest(t, addr, fmt.Sprintf(
+		"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body))
+}
+
+func TestShutdownWaitsForActiveRequest(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		close(started)
+		<-release
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       strings.NewReader("finished"),
+		}
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	_, err := conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("Failed to write request: %v", err)
+	}
+
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned before active request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.Contains(string(response), "finished") {
+		t.Errorf("Expected in-flight response to complete, got: %s", response)
+	}
+
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Errorf("Expected nil error from Shutdown, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Shutdown did not return after active request finished")
+	}
+}
+
+func TestShutdownClosesIdleConnections(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK"}
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	time.Sleep(10 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected idle connection to be closed, got: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected EOF on idle connection, got: %v", err)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	release := make(chan bool)
+	defer close(release)
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		<-release
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK"}
+	}
+
+	server, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func TestStartReturnsErrServerClosed(t *testing.T) {
+	server := NewHTTPServer(HTTPServerConfig{Addr: "localhost", Port: "0"})
+
+	startErr := make(chan error)
+	go func() {
+		startErr <- server.Start()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	server.Close()
+
+	select {
+	case err := <-startErr:
+		if err != ErrServerClosed {
+			t.Errorf("Expected ErrServerClosed, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Start did not return after Close")
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "httpx.sock")
+
+	server := NewHTTPServer(HTTPServerConfig{Addr: UnixAddrPrefix + path})
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       strings.NewReader("over unix"),
+		}
+	}
+
+	go server.Start()
+	defer server.Close()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		conn, err = net.Dial("unix", path)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect to unix socket: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.Contains(string(response), "over unix") {
+		t.Errorf("Expected response body over unix socket, got: %s", response)
+	}
+}
+
+func TestInheritedListenersOtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := ActivationListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("Expected no listeners for another pid, got %v, %v", listeners, err)
+	}
+}
+
+func BenchmarkHTTPServer(b *testing.B) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       strings.NewReader("OK"),
+		}
+	}
+
+	_, addr, cleanup := setupTestServer(nil, handler)
+	defer cleanup()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatalf("Failed to connect: %v", err)
+		}
+
+		request := "GET /bench HTTP/1.1\r\nHost: localhost\r\n\r\n"
+		conn.Write([]byte(request))
+
+		response := make([]byte, 256)
+		conn.Read(response)
+		conn.Close()
+	}
+}
+
+func benchmarkKeepAlive(b *testing.B, request string, handler StreamHandlerFunc) {
+	server := newTestServer()
+	server.StreamHandler = handler
+	server.maxKeepAliveRequests = b.N + 1
+
+	addr, cleanup := startTestServer(b, server)
+	defer cleanup()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		b.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := io.WriteString(conn, request); err != nil {
+			b.Fatalf("Failed to write request: %v", err)
+		}
+
+		res, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			b.Fatalf("Failed to read response: %v", err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
+func BenchmarkKeepAliveResponse(b *testing.B) {
+	benchmarkKeepAlive(b, "GET /bench HTTP/1.1\r\nHost: localhost\r\n\r\n", func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		w.Header().Set(CacheControlHeader, "no-store")
+		w.Header().Set("x-request-id", "bench")
+		w.Header().Set(ContentLengthHeader, "2")
+		w.Write([]byte("OK"))
+	})
+}
+
+func BenchmarkChunkedResponse(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 512)
+
+	benchmarkKeepAlive(b, "GET /bench HTTP/1.1\r\nHost: localhost\r\n\r\n", func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		for i := 0; i < 16; i++ {
+			w.Write(chunk)
+		}
+	})
+}
+
+func BenchmarkLargeChunkedResponse(b *testing.B) {
+	chunk := bytes.Repeat([]byte("x"), 64*1024)
+
+	benchmarkKeepAlive(b, "GET /bench HTTP/1.1\r\nHost: localhost\r\n\r\n", func(w ResponseWriter, req *HTTPRequest) {
+		for i := 0; i < 4; i++ {
+			w.Write(chunk)
+		}
+	})
 }
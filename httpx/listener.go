@@ -0,0 +1,83 @@
+package httpx
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func (s *HTTPServer) listen() ([]net.Listener, error) {
+	if s.socketActivation {
+		listeners, err := ActivationListeners()
+		if err != nil {
+			return nil, err
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("socket activation enabled but no file descriptors were passed")
+		}
+		return listeners, nil
+	}
+
+	if path, ok := strings.CutPrefix(s.addr, UnixAddrPrefix); ok {
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", s.addr, s.port))
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{listener}, nil
+}
+
+// ActivationListeners returns the listeners passed to this process by a
+// systemd-style service manager through LISTEN_PID and LISTEN_FDS. It returns
+// no listeners when the variables are missing or meant for another process.
+func ActivationListeners() ([]net.Listener, error) {
+	return inheritedListeners(listenFDsStart)
+}
+
+func inheritedListeners(firstFD int) ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", firstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(firstFD+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("error using inherited fd %d: %v", firstFD+i, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
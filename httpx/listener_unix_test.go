@@ -0,0 +1,52 @@
+//go:build unix
+
+package httpx
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer tcpListener.Close()
+
+	file, err := tcpListener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	defer file.Close()
+
+	// inheritedListeners takes ownership of the fd, so it gets its own copy;
+	// otherwise file would close the number again once another socket
+	// reuses it
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("Failed to duplicate listener fd: %v", err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := inheritedListeners(fd)
+	if err != nil {
+		t.Fatalf("Failed to use inherited listener: %v", err)
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 inherited listener, got %d", len(listeners))
+	}
+	defer listeners[0].Close()
+
+	if listeners[0].Addr().String() != tcpListener.Addr().String() {
+		t.Errorf("Expected listener on %s, got %s", tcpListener.Addr(), listeners[0].Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("Expected LISTEN_FDS to be unset after use")
+	}
+}
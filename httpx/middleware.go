@@ -0,0 +1,14 @@
+package httpx
+
+// Middleware wraps a StreamHandlerFunc to add behaviour around it, for example
+// by wrapping the ResponseWriter or the request body.
+type Middleware func(StreamHandlerFunc) StreamHandlerFunc
+
+// Chain applies middlewares to handler so that the first one listed is the
+// outermost and sees the request first.
+func Chain(handler StreamHandlerFunc, middlewares ...Middleware) StreamHandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,299 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var smugglingCorpus = []struct {
+	name      string
+	request   string
+	strictOK  bool
+	lenientOK bool
+}{
+	{
+		name:      "CL.TE",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+		lenientOK: true,
+	},
+	{
+		name:      "TE.CL",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n5c\r\nGPOST / HTTP/1.1\r\n",
+		lenientOK: false,
+	},
+	{
+		name:    "conflicting duplicate content-length",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+	},
+	{
+		name:    "conflicting content-length list",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 6\r\n\r\nhello!",
+	},
+	{
+		name:      "identical duplicate content-length",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+		strictOK:  true,
+		lenientOK: true,
+	},
+	{
+		name:    "negative content-length",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n",
+	},
+	{
+		name:    "signed content-length",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+	},
+	{
+		name:    "hex content-length",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+	},
+	{
+		name:    "overflowing content-length",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n",
+	},
+	{
+		name:      "transfer-encoding on HTTP/1.0",
+		request:   "POST / HTTP/1.0\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:    "obfuscated transfer-encoding",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+	},
+	{
+		name:    "chunked not final",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+	},
+	{
+		name:    "unsupported transfer coding",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+	},
+	{
+		name:      "whitespace before colon",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:      "tab as optional whitespace",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\tchunked\r\n\r\n0\r\n\r\n",
+		strictOK:  true,
+		lenientOK: true,
+	},
+	{
+		name:      "obs-fold",
+		request:   "GET / HTTP/1.1\r\nHost: a\r\nX-Folded: first\r\n second\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:      "obs-fold transfer-encoding",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:    "obs-fold without header",
+		request: "GET / HTTP/1.1\r\n Host: a\r\n\r\n",
+	},
+	{
+		name:      "bare LF line endings",
+		request:   "GET / HTTP/1.1\nHost: a\n\n",
+		lenientOK: true,
+	},
+	{
+		name:      "bare LF in chunk size",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\nhello\r\n0\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:    "chunk data overrun",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhelloGET / HTTP/1.1\r\n0\r\n\r\n",
+	},
+	{
+		name:      "whitespace after chunk size",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5 \r\nhello\r\n0\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:    "overflowing chunk size",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\nfffffffffffffffff1\r\nhello\r\n0\r\n\r\n",
+	},
+	{
+		name:    "prefixed chunk size",
+		request: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n0x5\r\nhello\r\n0\r\n\r\n",
+	},
+	{
+		name:      "chunk extension",
+		request:   "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n5;name=value\r\nhello\r\n0\r\n\r\n",
+		strictOK:  true,
+		lenientOK: true,
+	},
+	{
+		name:      "missing host",
+		request:   "GET / HTTP/1.1\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:      "duplicate host",
+		request:   "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:      "extra whitespace in request line",
+		request:   "GET  / HTTP/1.1\r\nHost: a\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:      "control character in header value",
+		request:   "GET / HTTP/1.1\r\nHost: a\r\nX-Bad: a\x00b\r\n\r\n",
+		lenientOK: true,
+	},
+	{
+		name:    "space in header name",
+		request: "GET / HTTP/1.1\r\nHost: a\r\nTransfer Encoding: chunked\r\n\r\n",
+	},
+	{
+		name:    "header without colon",
+		request: "GET / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding chunked\r\n\r\n",
+	},
+	{
+		name:    "unsupported version",
+		request: "GET / HTTP/2.0\r\nHost: a\r\n\r\n",
+	},
+	{
+		name:    "unsupported minor version",
+		request: "GET / HTTP/1.9\r\nHost: a\r\n\r\n",
+	},
+	{
+		name:    "malformed version",
+		request: "GET / HTTP/1.a\r\nHost: a\r\n\r\n",
+	},
+	{
+		name:      "leading empty line",
+		request:   "\r\nGET / HTTP/1.1\r\nHost: a\r\n\r\n",
+		strictOK:  true,
+		lenientOK: true,
+	},
+}
+
+// parseAndReadBody parses raw and reads the whole body, as a request is only
+// accepted once its framing turned out to be valid.
+func parseAndReadBody(server *HTTPServer, raw string) (*HTTPRequest, error) {
+	req, err := server.parseRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadAll(req.Body); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func TestSmugglingCorpus(t *testing.T) {
+	strict := NewHTTPServer(HTTPServerConfig{})
+	lenient := NewHTTPServer(HTTPServerConfig{LenientParsing: true})
+
+	for _, tt := range smugglingCorpus {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseAndReadBody(strict, tt.request); (err == nil) != tt.strictOK {
+				t.Errorf("strict: expected accepted=%v, got error: %v", tt.strictOK, err)
+			}
+			if _, err := parseAndReadBody(lenient, tt.request); (err == nil) != tt.lenientOK {
+				t.Errorf("lenient: expected accepted=%v, got error: %v", tt.lenientOK, err)
+			}
+		})
+	}
+}
+
+func TestLenientParsingNormalizesRequest(t *testing.T) {
+	lenient := NewHTTPServer(HTTPServerConfig{LenientParsing: true, EnableKeepAlive: true})
+
+	req, err := parseAndReadBody(lenient, "GET / HTTP/1.1\nHost: a\nX-Folded: first\n\tsecond\n\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := req.Headers.Get("X-Folded"); got != "first second" {
+		t.Errorf("Expected folded value to be joined, got %q", got)
+	}
+
+	req, err = parseAndReadBody(lenient,
+		"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 100\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !req.IsChunked || req.Headers.Has(ContentLengthHeader) {
+		t.Errorf("Expected transfer-encoding to override content-length, got %v", req.Headers)
+	}
+	if lenient.shouldKeepConnectionAlive(req) {
+		t.Errorf("Expected connection to close after a request with both framings")
+	}
+
+	req, err = parseAndReadBody(lenient,
+		"POST / HTTP/1.0\r\nHost: a\r\nConnection: keep-alive\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lenient.shouldKeepConnectionAlive(req) {
+		t.Errorf("Expected connection to close after a chunked HTTP/1.0 request")
+	}
+}
+
+func TestSmuggledRequestIsRejected(t *testing.T) {
+	var paths []string
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		paths = append(paths, req.Path)
+		return textResponse(200, "ok")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 44\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close, got: %v", err)
+	}
+
+	if !strings.Contains(string(response), "HTTP/1.1 400 Bad Request") {
+		t.Errorf("Expected 400 for smuggling attempt, got: %s", response)
+	}
+	if strings.Join(paths, ",") != "/first" {
+		t.Errorf("Expected only the first request to reach the handler, got %v", paths)
+	}
+}
+
+func TestUnsupportedVersionIsNotEchoed(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, "ok")
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{"HTTP/1.9", "HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+		{"HTTP/2.0", "HTTP/1.1 505 HTTP Version Not Supported\r\n"},
+		{"HTTP/1.a", "HTTP/1.1 400 Bad Request\r\n"},
+	}
+
+	for _, tt := range tests {
+		response := makeRequest(t, addr, "GET / "+tt.version+"\r\nHost: localhost\r\n\r\n")
+		if !strings.HasPrefix(response, tt.want) {
+			t.Errorf("%s: expected %q, got: %q", tt.version, tt.want, response)
+		}
+	}
+}
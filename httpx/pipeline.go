@@ -0,0 +1,100 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+type pipelinedResponse struct {
+	w    *response
+	buf  bytes.Buffer
+	done chan struct{}
+}
+
+// pipeline runs pipelined requests concurrently and writes their buffered
+// responses to the connection strictly in request order.
+type pipeline struct {
+	conn         net.Conn
+	out          *bufio.Writer
+	depth        int
+	writeTimeout time.Duration
+
+	pending []*pipelinedResponse
+	closed  bool
+}
+
+func (p *pipeline) empty() bool {
+	return len(p.pending) == 0
+}
+
+func (p *pipeline) full() bool {
+	return len(p.pending) >= p.depth
+}
+
+func (p *pipeline) dispatch(handler StreamHandlerFunc, w *response) {
+	pr := &pipelinedResponse{w: w, done: make(chan struct{})}
+	w.out = &pr.buf
+
+	p.pending = append(p.pending, pr)
+
+	go func() {
+		defer close(pr.done)
+		handler(w, w.req)
+		w.finish()
+	}()
+}
+
+// flushOne waits for the oldest response in flight and writes it out. It
+// reports false once the connection must not be used for further responses.
+func (p *pipeline) flushOne() bool {
+	pr := p.pending[0]
+	p.pending = p.pending[1:]
+
+	<-pr.done
+
+	if p.closed {
+		return false
+	}
+
+	if pr.w.err != nil {
+		fmt.Printf("Error writing response: %v\n", pr.w.err)
+		p.closed = true
+		return false
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+	if _, err := p.out.Write(pr.buf.Bytes()); err != nil {
+		fmt.Printf("Error writing response: %v\n", err)
+		p.closed = true
+		return false
+	}
+
+	if !pr.w.keepAlive {
+		p.closed = true
+	}
+
+	return !p.closed
+}
+
+// flush writes out every response in flight, in order, and sends everything
+// buffered for the connection.
+func (p *pipeline) flush() bool {
+	for !p.empty() {
+		if !p.flushOne() {
+			p.pending = nil
+		}
+	}
+
+	if !p.closed && p.out.Buffered() > 0 {
+		p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout))
+		if err := p.out.Flush(); err != nil {
+			fmt.Printf("Error writing response: %v\n", err)
+			p.closed = true
+		}
+	}
+
+	return !p.closed
+}
@@ -0,0 +1,248 @@
+package httpx
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps how many ranges one request may ask for. Requests for more
+// get the whole body.
+const maxRanges = 100
+
+var (
+	errInvalidRange       = errors.New("invalid range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange parses a Range header (RFC 9110 14.1.2) for a body of size
+// bytes. errInvalidRange means the header is ignored, errUnsatisfiableRange
+// that none of the ranges overlaps the body.
+func parseRange(header string, size int64) ([]byteRange, error) {
+	unit, specs, ok := strings.Cut(header, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+		if first == "" {
+			// suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			n = min(n, size)
+			ranges = append(ranges, byteRange{start: size - n, length: n})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start < 0 {
+			return nil, errInvalidRange
+		}
+
+		end := size - 1
+		if last != "" {
+			end, err = strconv.ParseInt(last, 10, 64)
+			if err != nil || end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, byteRange{start: start, length: end - start + 1})
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+
+	return ranges, nil
+}
+
+// serveRange answers a Range request for a seekable 200 response of size
+// bytes that offers ranges with Accept-Ranges: bytes, by rewriting res into a
+// 206 or 416 response. It returns the new body length.
+func (res *HTTPResponse) serveRange(req *HTTPRequest, size int64) int64 {
+	seeker, ok := res.Body.(io.ReadSeeker)
+	if !ok || res.StatusCode != http.StatusOK || req == nil {
+		return size
+	}
+	if !strings.EqualFold(res.Headers.Get(AcceptRangesHeader), "bytes") {
+		return size
+	}
+
+	rangeHeader := req.Headers.Get(RangeHeader)
+	if rangeHeader == "" || req.Method != http.MethodGet || !ifRangeMatches(req, res) {
+		return size
+	}
+
+	// the handler may share its header between responses
+	res.Headers = res.Headers.Clone()
+
+	ranges, err := parseRange(rangeHeader, size)
+	if err == errUnsatisfiableRange {
+		res.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		res.StatusText = ""
+		res.Headers.Set(ContentRangeHeader, fmt.Sprintf("bytes */%d", size))
+		res.Body = nil
+		return 0
+	}
+	if err != nil || len(ranges) > maxRanges || sumRanges(ranges) > size {
+		// overlapping or excessive ranges would cost more than the body
+		return size
+	}
+
+	base, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return size
+	}
+
+	res.StatusCode = http.StatusPartialContent
+	res.StatusText = ""
+
+	if len(ranges) == 1 {
+		res.Headers.Set(ContentRangeHeader, ranges[0].contentRange(size))
+		res.Body = rangeSection(seeker, base, ranges[0])
+		return ranges[0].length
+	}
+
+	boundary := randomBoundary()
+	contentType := res.Headers.Get(ContentTypeHeader)
+
+	var parts []io.Reader
+	var length int64
+	for i, r := range ranges {
+		var head strings.Builder
+		if i > 0 {
+			head.WriteString("\r\n")
+		}
+		head.WriteString("--" + boundary + "\r\n")
+		if contentType != "" {
+			head.WriteString("Content-Type: " + contentType + "\r\n")
+		}
+		head.WriteString("Content-Range: " + r.contentRange(size) + "\r\n\r\n")
+
+		parts = append(parts, strings.NewReader(head.String()), rangeSection(seeker, base, r))
+		length += int64(head.Len()) + r.length
+	}
+
+	tail := "\r\n--" + boundary + "--\r\n"
+	parts = append(parts, strings.NewReader(tail))
+	length += int64(len(tail))
+
+	res.Headers.Set(ContentTypeHeader, "multipart/byteranges; boundary="+boundary)
+	res.Body = io.MultiReader(parts...)
+	return length
+}
+
+func sumRanges(ranges []byteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	return total
+}
+
+// ifRangeMatches reports whether the ranges may be served (RFC 9110 13.1.5):
+// the If-Range validator has to match the response's strong ETag or exactly
+// its Last-Modified date.
+func ifRangeMatches(req *HTTPRequest, res *HTTPResponse) bool {
+	ifRange := strings.TrimSpace(req.Headers.Get(IfRangeHeader))
+	if ifRange == "" {
+		return true
+	}
+
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		etag := res.Headers.Get(ETagHeader)
+		return strings.HasPrefix(ifRange, `"`) && strings.HasPrefix(etag, `"`) && etag == ifRange
+	}
+
+	date, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(res.Headers.Get(LastModifiedHeader))
+	return err == nil && modified.Equal(date)
+}
+
+// rangeSection returns a reader for r, relative to offset base of seeker.
+// Files and other io.ReaderAt bodies get an io.SectionReader, which keeps
+// a single range of a file eligible for sendfile.
+func rangeSection(seeker io.ReadSeeker, base int64, r byteRange) io.Reader {
+	if readerAt, ok := seeker.(io.ReaderAt); ok {
+		return io.NewSectionReader(readerAt, base+r.start, r.length)
+	}
+	return &seekReader{seeker: seeker, offset: base + r.start, remaining: r.length}
+}
+
+// seekReader reads length bytes from offset, seeking only when first read so
+// that several of them can share one seeker in a multipart body.
+type seekReader struct {
+	seeker    io.ReadSeeker
+	offset    int64
+	remaining int64
+	seeked    bool
+}
+
+func (s *seekReader) Read(p []byte) (int, error) {
+	if !s.seeked {
+		if _, err := s.seeker.Seek(s.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.seeked = true
+	}
+
+	if s.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.remaining {
+		p = p[:s.remaining]
+	}
+
+	n, err := s.seeker.Read(p)
+	s.remaining -= int64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func randomBoundary() string {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("httpx: can't generate boundary: %v", err))
+	}
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,275 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rangeBody = "0123456789abcdefghij"
+
+func getRange(t *testing.T, addr string, headers ...string) (*http.Response, []byte) {
+	conn := makeRawConnection(t, addr)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	request := "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"
+	for i := 0; i+1 < len(headers); i += 2 {
+		request += headers[i] + ": " + headers[i+1] + "\r\n"
+	}
+	conn.Write([]byte(request + "\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+
+	return res, body
+}
+
+func rangeHandler(headers ...string) HandlerFunc {
+	return func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			Headers:    NewHeader(append([]string{ContentTypeHeader, "text/plain", AcceptRangesHeader, "bytes"}, headers...)...),
+			Body:       strings.NewReader(rangeBody),
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 15}}, nil},
+		{"bytes=-3", []byteRange{{17, 3}}, nil},
+		{"bytes=-50", []byteRange{{0, 20}}, nil},
+		{"bytes=10-100", []byteRange{{10, 10}}, nil},
+		{"bytes=0-0, 2-3", []byteRange{{0, 1}, {2, 2}}, nil},
+		{"BYTES = 1-1", []byteRange{{1, 1}}, nil},
+		{"bytes=20-, 30-40", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"bytes=5-2", nil, errInvalidRange},
+		{"bytes=a-b", nil, errInvalidRange},
+		{"bytes=1", nil, errInvalidRange},
+		{"items=0-1", nil, errInvalidRange},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, int64(len(rangeBody)))
+		if err != tt.err {
+			t.Errorf("parseRange(%q) error = %v, want %v", tt.header, err, tt.err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSingleRange(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, rangeHandler())
+	defer cleanup()
+
+	tests := []struct {
+		rangeHeader  string
+		contentRange string
+		body         string
+	}{
+		{"bytes=0-4", "bytes 0-4/20", "01234"},
+		{"bytes=15-", "bytes 15-19/20", "fghij"},
+		{"bytes=-2", "bytes 18-19/20", "ij"},
+	}
+
+	for _, tt := range tests {
+		res, body := getRange(t, addr, "Range", tt.rangeHeader)
+		if res.StatusCode != http.StatusPartialContent {
+			t.Errorf("%s: expected 206, got %d", tt.rangeHeader, res.StatusCode)
+		}
+		if got := res.Header.Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: expected Content-Range %q, got %q", tt.rangeHeader, tt.contentRange, got)
+		}
+		if res.ContentLength != int64(len(tt.body)) || string(body) != tt.body {
+			t.Errorf("%s: expected body %q, got %q (length %d)", tt.rangeHeader, tt.body, body, res.ContentLength)
+		}
+	}
+}
+
+func TestRangeIsAdvertised(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, rangeHandler())
+	defer cleanup()
+
+	res, body := getRange(t, addr)
+	if res.StatusCode != 200 || string(body) != rangeBody {
+		t.Errorf("Expected full 200 response, got %d %q", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Expected Accept-Ranges bytes, got %q", got)
+	}
+
+	// invalid ranges are ignored
+	res, body = getRange(t, addr, "Range", "bytes=9-3")
+	if res.StatusCode != 200 || string(body) != rangeBody {
+		t.Errorf("Expected invalid range to be ignored, got %d %q", res.StatusCode, body)
+	}
+}
+
+func TestRangeNeedsAcceptRanges(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Body: strings.NewReader(rangeBody)}
+	})
+	defer cleanup()
+
+	res, body := getRange(t, addr, "Range", "bytes=0-4")
+	if res.StatusCode != 200 || string(body) != rangeBody {
+		t.Errorf("Expected full 200 response, got %d %q", res.StatusCode, body)
+	}
+	if got := res.Header.Get("Accept-Ranges"); got != "" {
+		t.Errorf("Expected no Accept-Ranges, got %q", got)
+	}
+}
+
+func TestRangeKeepsHandlerHeader(t *testing.T) {
+	shared := NewHeader(ContentTypeHeader, "text/plain", AcceptRangesHeader, "bytes")
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, Headers: shared, Body: strings.NewReader(rangeBody)}
+	})
+	defer cleanup()
+
+	for _, rangeHeader := range []string{"bytes=0-4", "bytes=0-1, 3-4", "bytes=30-"} {
+		getRange(t, addr, "Range", rangeHeader)
+	}
+
+	if len(shared) != 2 || shared.Get(ContentTypeHeader) != "text/plain" || shared.Has(ContentRangeHeader) {
+		t.Errorf("Expected the handler's header to be left alone, got %v", shared)
+	}
+}
+
+func TestUnsatisfiableRange(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, rangeHandler())
+	defer cleanup()
+
+	res, body := getRange(t, addr, "Range", "bytes=20-")
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("Expected 416, got %d", res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Range"); got != "bytes */20" {
+		t.Errorf("Expected Content-Range bytes */20, got %q", got)
+	}
+	if len(body) != 0 {
+		t.Errorf("Expected empty body, got %q", body)
+	}
+}
+
+func TestMultipleRanges(t *testing.T) {
+	_, addr, cleanup := setupTestServer(t, rangeHandler())
+	defer cleanup()
+
+	res, body := getRange(t, addr, "Range", "bytes=0-1, 5-6, -1")
+	if res.StatusCode != http.StatusPartialContent {
+		t.Fatalf("Expected 206, got %d", res.StatusCode)
+	}
+	if res.ContentLength != int64(len(body)) {
+		t.Errorf("Content-Length %d does not match body length %d", res.ContentLength, len(body))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Expected multipart/byteranges, got %q", res.Header.Get("Content-Type"))
+	}
+
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/20", "01"},
+		{"bytes 5-6/20", "56"},
+		{"bytes 19-19/20", "j"},
+	}
+
+	reader := multipart.NewReader(strings.NewReader(string(body)), params["boundary"])
+	for i, w := range want {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("Part %d: %v", i, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != w.contentRange {
+			t.Errorf("Part %d: expected Content-Range %q, got %q", i, w.contentRange, got)
+		}
+		if got := part.Header.Get("Content-Type"); got != "text/plain" {
+			t.Errorf("Part %d: expected Content-Type text/plain, got %q", i, got)
+		}
+		data, _ := io.ReadAll(part)
+		if string(data) != w.body {
+			t.Errorf("Part %d: expected %q, got %q", i, w.body, data)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("Expected end of multipart body, got %v", err)
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	modified := "Wed, 21 Oct 2015 07:28:00 GMT"
+	_, addr, cleanup := setupTestServer(t, rangeHandler(ETagHeader, `"v1"`, LastModifiedHeader, modified))
+	defer cleanup()
+
+	tests := []struct {
+		ifRange string
+		partial bool
+	}{
+		{`"v1"`, true},
+		{`"v2"`, false},
+		{`W/"v1"`, false},
+		{modified, true},
+		{"Thu, 22 Oct 2015 07:28:00 GMT", false},
+		{"garbage", false},
+	}
+
+	for _, tt := range tests {
+		res, body := getRange(t, addr, "Range", "bytes=0-1", "If-Range", tt.ifRange)
+		if tt.partial && (res.StatusCode != 206 || string(body) != "01") {
+			t.Errorf("If-Range %s: expected partial response, got %d %q", tt.ifRange, res.StatusCode, body)
+		}
+		if !tt.partial && (res.StatusCode != 200 || string(body) != rangeBody) {
+			t.Errorf("If-Range %s: expected full response, got %d %q", tt.ifRange, res.StatusCode, body)
+		}
+	}
+}
+
+func TestFileRange(t *testing.T) {
+	file := writeTempFile(t, rangeBody)
+	_, addr, cleanup := setupTestServer(t, func(req *HTTPRequest) *HTTPResponse {
+		file.Seek(0, io.SeekStart)
+		return &HTTPResponse{StatusCode: 200, Headers: NewHeader(AcceptRangesHeader, "bytes"), Body: file}
+	})
+	defer cleanup()
+
+	res, body := getRange(t, addr, "Range", "bytes=4-7")
+	if res.StatusCode != 206 || string(body) != "4567" {
+		t.Errorf("Expected partial file content, got %d %q", res.StatusCode, body)
+	}
+
+	res, body = getRange(t, addr, "Range", "bytes=0-0,-1")
+	if res.StatusCode != 206 || !strings.Contains(string(body), "\r\n\r\n0\r\n") || !strings.Contains(string(body), "\r\n\r\nj\r\n") {
+		t.Errorf("Expected multipart file content, got %d %q", res.StatusCode, body)
+	}
+}
@@ -2,77 +2,372 @@ package httpx
 
 import (
 	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 )
 
+// MaxBytesError is returned by HTTPRequest.Body once a body grows past the
+// server's MaxRequestSize or the route's override.
+type MaxBytesError struct {
+	Limit int64
+}
+
+func (e *MaxBytesError) Error() string {
+	return fmt.Sprintf("httpx: request body exceeds %d bytes", e.Limit)
+}
+
+type maxBytesReader struct {
+	reader    io.Reader
+	limit     int64
+	remaining int64
+	err       error
+}
+
+func newMaxBytesReader(reader io.Reader, limit int64) *maxBytesReader {
+	return &maxBytesReader{reader: reader, limit: limit, remaining: limit}
+}
+
+func (m *maxBytesReader) Read(p []byte) (n int, err error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	// read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err = m.reader.Read(p)
+	if int64(n) <= m.remaining {
+		m.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(m.remaining)
+	m.remaining = 0
+	m.err = &MaxBytesError{Limit: m.limit}
+	return n, m.err
+}
+
+func (m *maxBytesReader) read() int64 {
+	return m.limit - m.remaining
+}
+
+// expectContinueReader sends the interim 100 Continue response the first
+// time the handler reads a body sent with "Expect: 100-continue".
+type expectContinueReader struct {
+	reader io.Reader
+	w      *response
+	sent   bool
+}
+
+func (e *expectContinueReader) Read(p []byte) (n int, err error) {
+	if !e.sent {
+		e.sent = true
+
+		// once the final response has started the client stops waiting
+		if !e.w.wroteHeader {
+			if _, err := io.WriteString(e.w.out, HTTP11Version+" 100 Continue\r\n\r\n"); err != nil {
+				return 0, err
+			}
+			e.w.flushBuffer()
+			if e.w.err != nil {
+				return 0, e.w.err
+			}
+		}
+	}
+
+	return e.reader.Read(p)
+}
+
+// supportedRequestCodings is advertised in Accept-Encoding when a request
+// body is refused.
+const supportedRequestCodings = "gzip, deflate"
+
+// contentCodings returns the codings applied to a request body, in the order
+// they were applied, or an error for one that can't be decoded.
+func contentCodings(values []string) ([]string, error) {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case "", "identity":
+			case "gzip", "x-gzip", "deflate":
+				codings = append(codings, coding)
+			default:
+				return nil, fmt.Errorf("unsupported content-encoding %q", coding)
+			}
+		}
+	}
+	return codings, nil
+}
+
+// decodingReader undoes the content codings of a request body. Decoders are
+// created on the first Read so that nothing is read from the connection, and
+// no 100 Continue sent, before the handler asks for the body.
+type decodingReader struct {
+	reader  io.Reader
+	codings []string
+	decoded io.Reader
+	err     error
+}
+
+func newDecodingReader(reader io.Reader, codings []string) *decodingReader {
+	return &decodingReader{reader: reader, codings: codings}
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	if d.decoded == nil && d.err == nil {
+		d.decoded, d.err = d.init()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.decoded.Read(p)
+}
+
+func (d *decodingReader) init() (io.Reader, error) {
+	reader := d.reader
+
+	// the last coding listed was applied last, so it is removed first
+	for i := len(d.codings) - 1; i >= 0; i-- {
+		switch d.codings[i] {
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(reader)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gzip body: %v", err)
+			}
+			reader = gz
+		case "deflate":
+			reader = newDeflateReader(reader)
+		}
+	}
+
+	return reader, nil
+}
+
+// newDeflateReader decodes the zlib format HTTP calls deflate, and falls back
+// to raw deflate data as sent by some clients.
+func newDeflateReader(reader io.Reader) io.Reader {
+	buffered := bufio.NewReader(reader)
+
+	header, err := buffered.Peek(2)
+	if err == nil && isZlibHeader(header) {
+		if zr, err := zlib.NewReader(buffered); err == nil {
+			return zr
+		}
+	}
+
+	return flate.NewReader(buffered)
+}
+
+// isZlibHeader checks the CMF and FLG bytes of a zlib stream (RFC 1950 2.2).
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
 type emptyReader struct{}
 
 func (e *emptyReader) Read(p []byte) (n int, err error) {
 	return 0, io.EOF
 }
 
+var (
+	errLineTooLong = errors.New("line too long")
+	errBareLF      = badRequest("bare LF line ending")
+)
+
+// readLine reads a line of at most limit bytes and strips its CRLF. Bare LF
+// endings are only accepted when lenient is set.
+func readLine(reader *bufio.Reader, limit int, lenient bool) (string, error) {
+	var line []byte
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+
+	if len(line) >= 2 && line[len(line)-2] == '\r' {
+		return string(line[:len(line)-2]), nil
+	}
+	if lenient {
+		return string(line[:len(line)-1]), nil
+	}
+
+	return "", errBareLF
+}
+
 type chunkedReader struct {
 	reader    *bufio.Reader
-	chunkLeft int
+	lenient   bool
+	chunkLeft int64
 	finished  bool
+	err       error
+
+	trailer     *Header
+	trailerSize int
 }
 
-func newChunkedReader(reader *bufio.Reader) *chunkedReader {
-	return &chunkedReader{reader: reader}
+func newChunkedReader(reader *bufio.Reader, lenient bool) *chunkedReader {
+	return &chunkedReader{reader: reader, lenient: lenient}
 }
 
 func (c *chunkedReader) Read(p []byte) (n int, err error) {
+	if c.err != nil {
+		return 0, c.err
+	}
 	if c.finished {
 		return 0, io.EOF
 	}
 
 	if c.chunkLeft == 0 {
-		sizeLine, err := c.reader.ReadString('\n')
+		chunkSize, err := c.readChunkSize()
 		if err != nil {
+			c.err = err
 			return 0, err
 		}
 
-		sizeStr := strings.TrimSpace(sizeLine)
-		if idx := strings.Index(sizeStr, ";"); idx != -1 {
-			sizeStr = sizeStr[:idx]
-		}
-
-		chunkSize, err := strconv.ParseInt(sizeStr, 16, 64)
-		if err != nil {
-			return 0, fmt.Errorf("invalid chunk size: %s", sizeStr)
-		}
-
-		c.chunkLeft = int(chunkSize)
-
-		if c.chunkLeft == 0 { //final chunk
-			for {
-				line, err := c.reader.ReadString('\n')
-				if err != nil {
-					return 0, err
-				}
-				if strings.TrimSpace(line) == "" {
-					break
-				}
+		if chunkSize == 0 { //final chunk
+			if err := c.readTrailer(); err != nil {
+				c.err = err
+				return 0, err
 			}
 			c.finished = true
 			return 0, io.EOF
 		}
+
+		c.chunkLeft = chunkSize
 	}
 
 	toRead := len(p)
-	if toRead > c.chunkLeft {
-		toRead = c.chunkLeft
+	if int64(toRead) > c.chunkLeft {
+		toRead = int(c.chunkLeft)
 	}
 
 	n, err = c.reader.Read(p[:toRead])
-	c.chunkLeft -= n
+	c.chunkLeft -= int64(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
 
 	if c.chunkLeft == 0 && err == nil {
-		c.reader.ReadString('\n')
+		// chunk data must be followed by exactly one line ending
+		line, lineErr := readLine(c.reader, maxChunkLineSize, c.lenient)
+		if lineErr == nil && line != "" {
+			lineErr = fmt.Errorf("missing CRLF after chunk data")
+		}
+		if lineErr != nil {
+			c.err = lineErr
+			return n, lineErr
+		}
+	}
+
+	if err != nil {
+		c.err = err
 	}
 
 	return n, err
 }
+
+func (c *chunkedReader) readChunkSize() (int64, error) {
+	sizeLine, err := readLine(c.reader, maxChunkLineSize, c.lenient)
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+
+	sizeStr := sizeLine
+	if idx := strings.Index(sizeStr, ";"); idx != -1 {
+		sizeStr = strings.TrimRight(sizeStr[:idx], " \t")
+	}
+	if c.lenient {
+		sizeStr = strings.TrimSpace(sizeStr)
+	}
+
+	if sizeStr == "" || len(sizeStr) > 15 {
+		return 0, fmt.Errorf("invalid chunk size: %q", sizeStr)
+	}
+	for i := 0; i < len(sizeStr); i++ {
+		if !isHexDigit(sizeStr[i]) {
+			return 0, fmt.Errorf("invalid chunk size: %q", sizeStr)
+		}
+	}
+
+	chunkSize, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid chunk size: %q", sizeStr)
+	}
+
+	return chunkSize, nil
+}
+
+func (c *chunkedReader) readTrailer() error {
+	var trailer Header
+	budget := c.trailerSize
+	if budget <= 0 {
+		budget = maxChunkLineSize
+	}
+
+	for {
+		if budget <= 0 {
+			return fmt.Errorf("trailer section too large")
+		}
+		line, err := readLine(c.reader, budget, c.lenient)
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			if err == errLineTooLong {
+				return fmt.Errorf("trailer section too large")
+			}
+			return err
+		}
+		budget -= len(line) + 2
+
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !validHeaderName(name) {
+			return fmt.Errorf("malformed trailer field %q", line)
+		}
+		if !forbiddenTrailer(name) {
+			trailer.Add(name, strings.Trim(value, " \t"))
+		}
+	}
+
+	if c.trailer != nil {
+		*c.trailer = trailer
+	}
+
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
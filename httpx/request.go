@@ -0,0 +1,172 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseTarget splits the request-target into a decoded Path and RawQuery.
+// It accepts the origin-form (/path?query), the absolute-form
+// (http://host/path?query) and, for OPTIONS, the asterisk-form (*).
+func (r *HTTPRequest) parseTarget(target string) error {
+	r.Host = r.Headers.Get(HostHeader)
+
+	switch {
+	case target == "*":
+		if r.Method != http.MethodOptions {
+			return fmt.Errorf("asterisk-form is only allowed for OPTIONS")
+		}
+		r.Path = "*"
+		r.query = url.Values{}
+		return nil
+
+	case strings.HasPrefix(target, "/"):
+
+	default:
+		scheme, rest, ok := strings.Cut(target, "://")
+		if !ok || !strings.EqualFold(scheme, "http") && !strings.EqualFold(scheme, "https") {
+			return fmt.Errorf("unsupported request-target form")
+		}
+
+		authority, pathAndQuery := rest, "/"
+		if idx := strings.IndexAny(rest, "/?"); idx != -1 {
+			authority, pathAndQuery = rest[:idx], rest[idx:]
+		}
+		if authority == "" {
+			return fmt.Errorf("missing authority")
+		}
+		if strings.HasPrefix(pathAndQuery, "?") {
+			pathAndQuery = "/" + pathAndQuery
+		}
+
+		// the authority of an absolute-form target replaces the host header
+		r.Host = authority
+		target = pathAndQuery
+	}
+
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	rawPath, _, _ = strings.Cut(rawPath, "#")
+	rawQuery, _, _ = strings.Cut(rawQuery, "#")
+
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return err
+	}
+
+	// only malformed escapes are rejected; url.ParseQuery also refuses
+	// semicolons, which Query() simply leaves out
+	if _, err := url.ParseQuery(strings.ReplaceAll(rawQuery, ";", "%3B")); err != nil {
+		return err
+	}
+
+	r.Path = path
+	r.RawQuery = rawQuery
+
+	return nil
+}
+
+// Query returns the decoded query string parameters. Keys may hold several
+// values; use Query().Get for the first one.
+func (r *HTTPRequest) Query() url.Values {
+	if r.query == nil {
+		query, _ := url.ParseQuery(r.RawQuery)
+		r.query = query
+	}
+	return r.query
+}
+
+// badRequestError marks a request that is malformed, as opposed to one that
+// could not be read because the connection failed. It is answered with
+// statusCode, or 400 when that is unset.
+type badRequestError struct {
+	reason     string
+	statusCode int
+}
+
+func (e *badRequestError) Error() string {
+	return "malformed request: " + e.reason
+}
+
+func badRequest(format string, args ...any) error {
+	return &badRequestError{reason: fmt.Sprintf(format, args...)}
+}
+
+// validVersion reports whether version is a well-formed HTTP-version, which
+// doesn't mean it is supported.
+func validVersion(version string) bool {
+	return len(version) == len(HTTP11Version) && strings.HasPrefix(version, "HTTP/") &&
+		isDigit(version[5]) && version[6] == '.' && isDigit(version[7])
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func validHeaderName(name string) bool {
+	return validToken(name)
+}
+
+// validHeaderValue rejects control characters other than horizontal tab.
+func validHeaderValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// parseContentLength accepts repeated content-length values only when they
+// all agree, and only plain decimal digits.
+func parseContentLength(values []string) (int64, error) {
+	length := int64(-1)
+
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.Trim(part, " \t")
+			if part == "" {
+				return 0, fmt.Errorf("invalid content-length: %q", value)
+			}
+			for i := 0; i < len(part); i++ {
+				if part[i] < '0' || part[i] > '9' {
+					return 0, fmt.Errorf("invalid content-length: %q", value)
+				}
+			}
+
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid content-length: %q", value)
+			}
+			if length != -1 && n != length {
+				return 0, fmt.Errorf("conflicting content-length values")
+			}
+			length = n
+		}
+	}
+
+	return length, nil
+}
@@ -0,0 +1,629 @@
+package httpx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrHijacked       = errors.New("httpx: connection has been hijacked")
+	ErrContentLength  = errors.New("httpx: wrote more than the declared content-length")
+	ErrNotHijackable  = errors.New("httpx: pipelined responses can't be hijacked")
+	ErrBodyNotAllowed = errors.New("httpx: response status does not allow a body")
+)
+
+// ResponseWriter lets a StreamHandlerFunc send headers first and then
+// stream the body. Headers are sent on the first call to WriteHeader, Write
+// or Flush; without a content-length header the body is sent chunked on
+// HTTP/1.1 and delimited by closing the connection on HTTP/1.0.
+type ResponseWriter interface {
+	Header() *Header
+	WriteHeader(statusCode int)
+	Write(p []byte) (int, error)
+	Flush() error
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+type StreamHandlerFunc func(w ResponseWriter, req *HTTPRequest)
+
+// Stream adapts h to a StreamHandlerFunc, writing the returned HTTPResponse
+// through the ResponseWriter.
+func (h HandlerFunc) Stream() StreamHandlerFunc {
+	return func(w ResponseWriter, req *HTTPRequest) {
+		rw, isResponse := w.(*response)
+
+		res := h(req)
+		if res == nil {
+			w.Header().Set(ContentTypeHeader, "text/plain")
+			w.Header().Set(ConnectionHeader, CloseHeader)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Handler returned nil"))
+			return
+		}
+
+		if closer, ok := res.Body.(io.Closer); ok && res.closeBody {
+			defer closer.Close()
+		}
+
+		// trailers can only follow a chunked body
+		length := int64(-1)
+		if !res.Headers.Has(TrailerHeader) {
+			length = res.bodyLength()
+		}
+		if length >= 0 {
+			length = res.serveRange(req, length)
+		}
+
+		for _, field := range res.Headers {
+			w.Header().Add(field.Name, field.Value)
+		}
+		if length >= 0 {
+			w.Header().Set(ContentLengthHeader, strconv.FormatInt(length, 10))
+		}
+
+		if isResponse {
+			rw.writeHeader(res.StatusCode, res.StatusText)
+		} else {
+			w.WriteHeader(res.StatusCode)
+		}
+
+		// HEAD, 1xx, 204 and 304 responses never carry the body
+		if res.Body == nil || isResponse && !rw.sendsBody() {
+			return
+		}
+
+		var err error
+		switch {
+		case length < 0:
+			// a body of unknown length may be produced slowly, so each piece
+			// is sent as soon as it has been read
+			_, err = io.Copy(flushWriter{w}, res.Body)
+		case isResponse:
+			// io.Copy would prefer (*os.File).WriteTo, which hides the file
+			// from the sendfile path
+			_, err = rw.ReadFrom(res.Body)
+		default:
+			_, err = io.Copy(w, res.Body)
+		}
+
+		if err != nil && isResponse {
+			rw.fail(fmt.Errorf("error streaming body: %v", err))
+			return
+		}
+
+		// trailer values are known once the body has been read
+		for _, field := range res.Trailer {
+			w.Header().Set(field.Name, field.Value)
+		}
+	}
+}
+
+var writerPool sync.Pool
+
+// newBufferedWriter returns a pooled writer so that a response is sent with
+// as few syscalls as possible.
+func newBufferedWriter(conn net.Conn) *bufio.Writer {
+	if v := writerPool.Get(); v != nil {
+		bw := v.(*bufio.Writer)
+		bw.Reset(conn)
+		return bw
+	}
+	return bufio.NewWriterSize(conn, writeBufferSize)
+}
+
+func putBufferedWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
+
+type response struct {
+	conn   net.Conn
+	bw     *bufio.Writer
+	out    io.Writer
+	req    *HTTPRequest
+	server *HTTPServer
+
+	version    string
+	headers    Header
+	statusCode int
+
+	wroteHeader   bool
+	head          bool
+	bodyless      bool
+	chunked       bool
+	trailers      []string
+	contentLength int64
+	written       int64
+
+	keepAlive bool
+	remaining int
+	hijacked  bool
+
+	err error
+}
+
+func (s *HTTPServer) newResponse(conn net.Conn, bw *bufio.Writer, req *HTTPRequest, keepAlive bool, remaining int) *response {
+	version := HTTP11Version
+	if req != nil {
+		version = req.Version
+	}
+
+	return &response{
+		conn:          conn,
+		bw:            bw,
+		out:           bw,
+		req:           req,
+		server:        s,
+		version:       version,
+		contentLength: -1,
+		keepAlive:     keepAlive,
+		remaining:     remaining,
+	}
+}
+
+func (w *response) Header() *Header {
+	return &w.headers
+}
+
+// WriteHeader sends the response header. Informational 1xx statuses other
+// than 101 are sent as interim responses and a final status can follow.
+func (w *response) WriteHeader(statusCode int) {
+	if isInterimStatus(statusCode) {
+		w.writeInterim(statusCode)
+		return
+	}
+	w.writeHeader(statusCode, "")
+}
+
+func (w *response) writeInterim(statusCode int) {
+	// HTTP/1.0 clients don't understand interim responses (RFC 9110 15.2)
+	if w.wroteHeader || w.hijacked || w.err != nil || w.version == HTTP10Version {
+		return
+	}
+
+	if statusCode == http.StatusContinue && w.req != nil && w.req.expect != nil {
+		w.req.expect.sent = true
+	}
+
+	if err := w.writeHead(statusCode, "", true); err != nil {
+		w.fail(fmt.Errorf("error writing interim response: %v", err))
+		return
+	}
+	w.flushBuffer()
+}
+
+func (w *response) writeHeader(statusCode int, statusText string) {
+	if w.wroteHeader || w.hijacked {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = statusCode
+	w.head = w.req != nil && w.req.Method == http.MethodHead
+	w.bodyless = !bodyAllowedForStatus(statusCode)
+	w.trailers = declaredTrailers(w.headers)
+
+	if !w.server.disableDate && !w.headers.Has(DateHeader) {
+		w.headers.Set(DateHeader, serverDate.get(time.Now()))
+	}
+	if w.server.serverName != "" && !w.headers.Has(ServerHeader) {
+		w.headers.Set(ServerHeader, w.server.serverName)
+	}
+
+	if w.bodyless {
+		// the response ends with the header section, so there is no framing
+		w.headers.Del(ContentLengthHeader)
+		w.headers.Del(TransferEncodingHeader)
+		w.headers.Del(TrailerHeader)
+		w.trailers = nil
+		w.contentLength = 0
+	}
+
+	if len(w.trailers) > 0 && w.version == HTTP11Version {
+		// trailers are only carried by the chunked encoding
+		w.headers.Del(ContentLengthHeader)
+	}
+
+	if w.headers.Has(ContentLengthHeader) {
+		contentLength := w.headers.Get(ContentLengthHeader)
+		if length, err := strconv.ParseInt(contentLength, 10, 64); err == nil && length >= 0 {
+			w.contentLength = length
+		} else {
+			w.headers.Del(ContentLengthHeader)
+		}
+	}
+
+	// the body is framed by the server, so a transfer-encoding set by the
+	// handler must not contradict content-length or a close-delimited body
+	w.headers.Del(TransferEncodingHeader)
+
+	// a HEAD response reports the framing a GET would get but sends no body
+	if w.contentLength < 0 {
+		if w.version == HTTP11Version {
+			w.chunked = true
+			w.headers.Set(TransferEncodingHeader, "chunked")
+		} else if !w.head {
+			// body is delimited by closing the connection
+			w.keepAlive = false
+		}
+	}
+
+	if strings.ToLower(w.headers.Get(ConnectionHeader)) == CloseHeader || w.server.shuttingDown() {
+		w.keepAlive = false
+	}
+
+	if w.bodyTooLarge() || w.unreadBody() > w.server.maxDrainSize {
+		w.keepAlive = false
+	}
+
+	// the handler refused the body before asking for it, so the client may
+	// or may not send it and the connection can't be reused
+	if w.req != nil && w.req.expect != nil && !w.req.expect.sent {
+		w.keepAlive = false
+	}
+
+	if statusCode == http.StatusSwitchingProtocols {
+		// the connection now belongs to the protocol named in Upgrade, so the
+		// handler's Connection header is kept and the server won't reuse it
+		w.keepAlive = false
+	} else if w.keepAlive {
+		w.headers.Set(ConnectionHeader, KeepAliveHeader)
+		w.headers.Set(KeepAliveHeader, fmt.Sprintf("timeout=%d, max=%d",
+			int(w.server.keepAliveTimeout.Seconds()), w.remaining))
+	} else {
+		w.headers.Set(ConnectionHeader, CloseHeader)
+		w.headers.Del(KeepAliveHeader)
+	}
+
+	if err := w.writeHead(statusCode, statusText, false); err != nil {
+		w.fail(fmt.Errorf("error writing header: %v", err))
+	}
+}
+
+// writeHead sends the status line and all header fields in a single write.
+// Interim responses leave out the fields that describe the final message.
+func (w *response) writeHead(statusCode int, statusText string, interim bool) error {
+	if statusText == "" && !w.server.disableStatusText {
+		statusText = http.StatusText(statusCode)
+	}
+
+	head := make([]byte, 0, 256)
+	head = append(head, w.version...)
+	head = append(head, ' ')
+	head = strconv.AppendInt(head, int64(statusCode), 10)
+	head = append(head, ' ')
+	head = append(head, statusText...)
+	head = append(head, "\r\n"...)
+
+	for _, field := range w.headers {
+		if w.isTrailer(field.Name) || interim && framingHeader(field.Name) {
+			continue
+		}
+		head = appendHeaderField(head, field.Name, field.Value)
+	}
+	head = append(head, "\r\n"...)
+
+	_, err := w.out.Write(head)
+	return err
+}
+
+// isInterimStatus reports whether statusCode is an interim response, which
+// a final status follows. 101 is final for HTTP, the connection switches
+// protocols after it.
+func isInterimStatus(statusCode int) bool {
+	return statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols
+}
+
+// bodyAllowedForStatus reports whether a response with the given status may
+// have a body (RFC 9110 6.4.1).
+func bodyAllowedForStatus(statusCode int) bool {
+	switch {
+	case statusCode >= 100 && statusCode < 200:
+		return false
+	case statusCode == http.StatusNoContent, statusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func framingHeader(name string) bool {
+	switch strings.ToLower(name) {
+	case ContentLengthHeader, TransferEncodingHeader, TrailerHeader, ConnectionHeader, KeepAliveHeader:
+		return true
+	}
+	return false
+}
+
+// sendsBody reports whether anything written after the header reaches the
+// client.
+func (w *response) sendsBody() bool {
+	return w.wroteHeader && !w.head && !w.bodyless
+}
+
+func appendHeaderField(buf []byte, name, value string) []byte {
+	buf = append(buf, CanonicalHeaderKey(name)...)
+	buf = append(buf, ": "...)
+	buf = append(buf, headerValueReplacer.Replace(value)...)
+	return append(buf, "\r\n"...)
+}
+
+func (w *response) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.bodyless {
+		return 0, ErrBodyNotAllowed
+	}
+	if len(p) == 0 || w.head {
+		return len(p), nil
+	}
+
+	if w.chunked {
+		return w.writeChunk(p)
+	}
+
+	if w.contentLength >= 0 && w.written+int64(len(p)) > w.contentLength {
+		return 0, ErrContentLength
+	}
+
+	n, err := w.out.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		w.fail(err)
+	}
+
+	return n, err
+}
+
+// ReadFrom hands a fixed-length file body to the kernel (sendfile/splice)
+// instead of copying it through user space. io.Copy reaches it for an
+// io.SectionReader, but an *os.File has to be passed to ReadFrom directly
+// since io.Copy prefers (*os.File).WriteTo.
+func (w *response) ReadFrom(src io.Reader) (int64, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.bodyless {
+		return 0, ErrBodyNotAllowed
+	}
+	if w.head {
+		return 0, nil
+	}
+
+	// a *net.TCPConn sends a file given to its ReadFrom with sendfile
+	conn, isReaderFrom := w.conn.(io.ReaderFrom)
+	if !isReaderFrom || w.chunked || w.contentLength < 0 || w.out != io.Writer(w.bw) {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	var file *os.File
+	limit := w.contentLength - w.written
+	section, isSection := src.(*io.SectionReader)
+
+	switch r := src.(type) {
+	case *os.File:
+		file = r
+	case *io.SectionReader:
+		outer, start, size := r.Outer()
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if f, ok := outer.(*os.File); ok && err == nil {
+			// sendfile reads from the file offset, so move it to the section
+			if _, err := f.Seek(start+pos, io.SeekStart); err == nil {
+				file = f
+				limit = min(limit, size-pos)
+			}
+		}
+	}
+
+	if file == nil {
+		return io.Copy(writerOnly{w}, src)
+	}
+
+	if err := w.bw.Flush(); err != nil {
+		w.fail(err)
+		return 0, err
+	}
+
+	n, err := conn.ReadFrom(io.LimitReader(file, limit))
+	w.written += n
+	if isSection {
+		section.Seek(n, io.SeekCurrent)
+	}
+
+	if err != nil {
+		w.fail(err)
+	}
+
+	return n, err
+}
+
+type flushWriter struct {
+	w ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.w.Flush()
+	}
+	return n, err
+}
+
+// writerOnly hides ReadFrom so io.Copy falls back to plain writes.
+type writerOnly struct {
+	io.Writer
+}
+
+var crlf = []byte("\r\n")
+
+func (w *response) writeChunk(p []byte) (int, error) {
+	chunkSize := strconv.AppendInt(make([]byte, 0, 18), int64(len(p)), 16)
+	chunkSize = append(chunkSize, crlf...)
+
+	// small chunks are copied into the buffer, large ones skip it and are
+	// sent together with their framing in one writev
+	var dst io.Writer = w.out
+	if w.out == io.Writer(w.bw) && len(p) >= w.bw.Available() {
+		if err := w.bw.Flush(); err != nil {
+			w.fail(err)
+			return 0, err
+		}
+		dst = w.conn
+	}
+
+	chunk := net.Buffers{chunkSize, p, crlf}
+	if _, err := chunk.WriteTo(dst); err != nil {
+		w.fail(err)
+		return 0, err
+	}
+	w.written += int64(len(p))
+
+	return len(p), nil
+}
+
+func (w *response) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err == nil {
+		w.flushBuffer()
+	}
+	return w.err
+}
+
+// flushBuffer sends whatever is buffered for the connection. Pipelined
+// responses are written out by the pipeline in request order instead.
+func (w *response) flushBuffer() {
+	if w.out != io.Writer(w.bw) {
+		return
+	}
+	if err := w.bw.Flush(); err != nil {
+		w.fail(err)
+	}
+}
+
+// Hijack hands the connection over to the caller. The server stops tracking
+// it and will neither write to nor close it after the handler returns.
+func (w *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.out != io.Writer(w.bw) {
+		return nil, nil, ErrNotHijackable
+	}
+	if err := w.bw.Flush(); err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+
+	w.conn.SetDeadline(time.Time{})
+	w.server.trackConn(w.conn, false)
+
+	reader := bufio.NewReader(w.conn)
+	if w.req != nil && w.req.reader != nil {
+		reader = w.req.reader
+	}
+
+	return w.conn, bufio.NewReadWriter(reader, bufio.NewWriter(w.conn)), nil
+}
+
+func (w *response) isTrailer(name string) bool {
+	for _, trailer := range w.trailers {
+		if strings.EqualFold(trailer, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyTooLarge reports whether the request body hit its size limit, in which
+// case the rest of it can't be skipped safely and the connection must close.
+func (w *response) bodyTooLarge() bool {
+	return w.req != nil && w.req.body != nil && w.req.body.err != nil
+}
+
+// unreadBody returns how many bytes of a fixed-length request body the
+// handler has not read yet.
+func (w *response) unreadBody() int64 {
+	if w.req == nil || w.req.body == nil || w.req.BodySize <= 0 {
+		return 0
+	}
+	return w.req.BodySize - w.req.body.read()
+}
+
+func (w *response) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+	w.keepAlive = false
+}
+
+// finish completes the response after the handler has returned.
+func (w *response) finish() error {
+	if w.hijacked {
+		return nil
+	}
+
+	if !w.wroteHeader {
+		if !w.headers.Has(ContentLengthHeader) && !w.headers.Has(TrailerHeader) {
+			w.headers.Set(ContentLengthHeader, "0")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.bodyTooLarge() {
+		w.keepAlive = false
+	}
+
+	if !w.sendsBody() {
+		return nil
+	}
+
+	if w.chunked {
+		// final size 0 chunk, followed by the trailer section
+		lastChunk := []byte("0\r\n")
+		for _, name := range w.trailers {
+			for _, value := range w.headers.Values(name) {
+				lastChunk = appendHeaderField(lastChunk, name, value)
+			}
+		}
+		lastChunk = append(lastChunk, crlf...)
+
+		if _, err := w.out.Write(lastChunk); err != nil {
+			w.fail(err)
+			return err
+		}
+	} else if w.contentLength >= 0 && w.written < w.contentLength {
+		w.fail(fmt.Errorf("short body: wrote %d of %d bytes", w.written, w.contentLength))
+		return w.err
+	}
+
+	return nil
+}
@@ -0,0 +1,635 @@
+package httpx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func readResponseHead(t *testing.T, reader *bufio.Reader) (string, map[string]string) {
+	statusLine, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read status line: %v", err)
+	}
+
+	headers := make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read header line: %v", err)
+		}
+		if line == "\r\n" {
+			break
+		}
+
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 {
+			headers[strings.ToLower(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	return strings.TrimSpace(statusLine), headers
+}
+
+func TestStreamHandlerChunked(t *testing.T) {
+	flushed := make(chan bool)
+	release := make(chan bool)
+
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		w.WriteHeader(202)
+
+		if _, err := w.Write([]byte("first ")); err != nil {
+			t.Errorf("Unexpected write error: %v", err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Errorf("Unexpected flush error: %v", err)
+		}
+
+		close(flushed)
+		<-release
+
+		w.Write([]byte("second"))
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	statusLine, headers := readResponseHead(t, reader)
+
+	if statusLine != "HTTP/1.1 202 Accepted" {
+		t.Errorf("Expected HTTP/1.1 202 Accepted, got: %s", statusLine)
+	}
+	if headers[TransferEncodingHeader] != "chunked" {
+		t.Errorf("Expected chunked transfer-encoding, got: %v", headers)
+	}
+
+	<-flushed
+	firstChunk, _ := reader.ReadString('\n')
+	if strings.TrimSpace(firstChunk) != "6" {
+		t.Errorf("Expected first chunk before handler returned, got: %q", firstChunk)
+	}
+
+	close(release)
+
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+	if string(rest) != "first \r\n6\r\nsecond\r\n0\r\n\r\n" {
+		t.Errorf("Unexpected chunked body: %q", rest)
+	}
+}
+
+func TestStreamHandlerContentLength(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentLengthHeader, "5")
+		w.Write([]byte("hello"))
+
+		if _, err := w.Write([]byte("!")); err != ErrContentLength {
+			t.Errorf("Expected ErrContentLength, got: %v", err)
+		}
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	if !strings.Contains(response, "HTTP/1.1 200 OK") {
+		t.Errorf("Expected HTTP/1.1 200 OK, got: %s", response)
+	}
+	if !strings.HasSuffix(response, "\r\n\r\nhello") {
+		t.Errorf("Expected fixed-length body, got: %q", response)
+	}
+	if strings.Contains(response, "Transfer-Encoding") {
+		t.Errorf("Expected no transfer-encoding with content-length, got: %s", response)
+	}
+}
+
+func TestHandlerTransferEncodingIsDropped(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentLengthHeader, "5")
+		w.Header().Set(TransferEncodingHeader, "chunked")
+		w.Write([]byte("hello"))
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.Contains(response, "Content-Length: 5\r\n") || !strings.HasSuffix(response, "\r\n\r\nhello") {
+		t.Errorf("Expected fixed-length body, got: %q", response)
+	}
+	if strings.Contains(strings.ToLower(response), "transfer-encoding") {
+		t.Errorf("Expected only content-length framing, got: %q", response)
+	}
+}
+
+func TestStreamHandlerHTTP10ClosesConnection(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Write([]byte("until close"))
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to close after body, got: %v", err)
+	}
+	if !strings.HasSuffix(string(response), "\r\n\r\nuntil close") {
+		t.Errorf("Expected close-delimited body, got: %q", response)
+	}
+}
+
+func TestStreamHandlerTrailers(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(TrailerHeader, "X-Checksum, X-Count")
+		w.Header().Set(ContentLengthHeader, "5")
+		w.Write([]byte("hello"))
+
+		w.Header().Set("x-checksum", "abc123")
+		w.Header().Set("x-count", "5")
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	_, headers := readResponseHead(t, reader)
+
+	if headers[TransferEncodingHeader] != "chunked" {
+		t.Errorf("Expected trailers to force chunked encoding, got: %v", headers)
+	}
+	if headers[TrailerHeader] != "X-Checksum, X-Count" {
+		t.Errorf("Expected Trailer header to be announced, got: %v", headers)
+	}
+	if _, ok := headers["x-checksum"]; ok {
+		t.Errorf("Expected trailer field to be left out of the header section")
+	}
+
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "5\r\nhello\r\n0\r\nX-Checksum: abc123\r\nX-Count: 5\r\n\r\n" {
+		t.Errorf("Unexpected chunked body with trailers: %q", rest)
+	}
+}
+
+func TestHandlerFuncTrailers(t *testing.T) {
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		res := &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Headers:    NewHeader(TrailerHeader, "X-Checksum"),
+			Body:       strings.NewReader("payload"),
+		}
+		res.Trailer.Set("x-checksum", "sha-ok")
+		return res
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(response, "7\r\npayload\r\n0\r\nX-Checksum: sha-ok\r\n\r\n") {
+		t.Errorf("Expected checksum trailer after body, got: %q", response)
+	}
+}
+
+func TestStreamHandlerHijack(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		conn, rw, err := w.Hijack()
+		if err != nil {
+			t.Errorf("Unexpected hijack error: %v", err)
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			rw.WriteString("raw protocol\n")
+			rw.Flush()
+
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo " + line)
+			rw.Flush()
+		}()
+
+		if _, err := w.Write([]byte("ignored")); err != ErrHijacked {
+			t.Errorf("Expected ErrHijacked, got: %v", err)
+		}
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	line, _ := reader.ReadString('\n')
+	if line != "raw protocol\n" {
+		t.Errorf("Expected raw protocol greeting, got: %q", line)
+	}
+
+	conn.Write([]byte("ping\n"))
+	line, _ = reader.ReadString('\n')
+	if line != "echo ping\n" {
+		t.Errorf("Expected echo over hijacked connection, got: %q", line)
+	}
+}
+
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int32
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+type countingListener struct {
+	net.Listener
+	writes atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, writes: &l.writes}, nil
+}
+
+func TestResponseIsWrittenOnce(t *testing.T) {
+	server := newTestServer()
+	server.StreamHandler = func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set(ContentTypeHeader, "text/plain")
+		w.Header().Set(CacheControlHeader, "no-store")
+		w.Header().Set("x-request-id", "42")
+		for i := 0; i < 4; i++ {
+			w.Write([]byte("chunk"))
+		}
+	}
+
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	listener := &countingListener{Listener: inner}
+	go server.Serve(listener)
+	defer server.Close()
+
+	response := makeRequest(t, inner.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(response, "5\r\nchunk\r\n0\r\n\r\n") {
+		t.Errorf("Unexpected response: %q", response)
+	}
+	if writes := listener.writes.Load(); writes != 1 {
+		t.Errorf("Expected headers and body in a single write, got %d writes", writes)
+	}
+}
+
+func writeTempFile(t *testing.T, content string) *os.File {
+	path := filepath.Join(t.TempDir(), "body.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	return file
+}
+
+func TestFileResponseBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 10000)
+	file := writeTempFile(t, content)
+
+	handler := func(req *HTTPRequest) *HTTPResponse {
+		file.Seek(0, io.SeekStart)
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK", Body: file}
+	}
+
+	_, addr, cleanup := setupTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		conn.Write([]byte("GET /file HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+		_, headers := readResponseHead(t, reader)
+		if headers[ContentLengthHeader] != "100000" {
+			t.Fatalf("Expected content-length 100000, got: %v", headers)
+		}
+
+		body := make([]byte, len(content))
+		if _, err := io.ReadFull(reader, body); err != nil {
+			t.Fatalf("Failed to read file body: %v", err)
+		}
+		if string(body) != content {
+			t.Errorf("File body does not match on request %d", i+1)
+		}
+	}
+}
+
+func TestSectionResponseBody(t *testing.T) {
+	file := writeTempFile(t, "headerPAYLOADtrailer")
+
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		section := io.NewSectionReader(file, 6, 7)
+		w.Header().Set(ContentLengthHeader, "7")
+		if n, err := io.Copy(w, section); n != 7 || err != nil {
+			t.Errorf("Expected 7 bytes copied, got %d (%v)", n, err)
+		}
+		if pos, _ := section.Seek(0, io.SeekCurrent); pos != 7 {
+			t.Errorf("Expected section reader to be consumed, at %d", pos)
+		}
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(response, "\r\n\r\nPAYLOAD") {
+		t.Errorf("Expected section of the file as body, got: %q", response)
+	}
+}
+
+// sendfileConn records the files handed to ReadFrom, which a *net.TCPConn
+// sends with sendfile.
+type sendfileConn struct {
+	*net.TCPConn
+	files chan *os.File
+}
+
+func (c sendfileConn) ReadFrom(r io.Reader) (int64, error) {
+	if limited, ok := r.(*io.LimitedReader); ok {
+		if file, ok := limited.R.(*os.File); ok {
+			select {
+			case c.files <- file:
+			default:
+			}
+		}
+	}
+	return c.TCPConn.ReadFrom(r)
+}
+
+type sendfileListener struct {
+	net.Listener
+	files chan *os.File
+}
+
+func (l *sendfileListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return sendfileConn{TCPConn: conn.(*net.TCPConn), files: l.files}, nil
+}
+
+func TestFileResponseBodyUsesSendfile(t *testing.T) {
+	file := writeTempFile(t, "sent with sendfile")
+
+	server := newTestServer()
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK", Body: file}
+	}
+
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	listener := &sendfileListener{Listener: inner, files: make(chan *os.File, 1)}
+	go server.Serve(listener)
+	defer server.Close()
+
+	response := makeRequest(t, inner.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(response, "\r\n\r\nsent with sendfile") {
+		t.Errorf("Expected file as body, got: %q", response)
+	}
+
+	select {
+	case got := <-listener.files:
+		if got != file {
+			t.Errorf("Expected the body file to reach ReadFrom, got %s", got.Name())
+		}
+	default:
+		t.Error("Expected the file body to be handed to the connection's ReadFrom")
+	}
+}
+
+func TestFileResponseBodyWithoutTCPConn(t *testing.T) {
+	file := writeTempFile(t, "not sent with sendfile")
+
+	server := newTestServer()
+	server.Handler = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 200, StatusText: "OK", Body: file}
+	}
+
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(&countingListener{Listener: inner})
+	defer server.Close()
+
+	response := makeRequest(t, inner.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasSuffix(response, "\r\n\r\nnot sent with sendfile") {
+		t.Errorf("Expected file body through the fallback copy, got: %q", response)
+	}
+}
+
+func TestHeadResponseOmitsBody(t *testing.T) {
+	router := NewRouter()
+	router.Get("/items", func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, "item list")
+	})
+
+	_, addr, cleanup := setupTestServer(t, router.ServeRequest)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte("HEAD /items HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"GET /items HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	statusLine, headers := readResponseHead(t, reader)
+	if statusLine != "HTTP/1.1 200 OK" || headers[ContentLengthHeader] != "9" {
+		t.Errorf("Expected HEAD to report the GET length, got: %s %v", statusLine, headers)
+	}
+
+	// the next response has to follow the HEAD header section directly
+	statusLine, headers = readResponseHead(t, reader)
+	if statusLine != "HTTP/1.1 200 OK" || headers[ContentLengthHeader] != "9" {
+		t.Errorf("Expected GET response after HEAD, got: %s %v", statusLine, headers)
+	}
+
+	body, _ := io.ReadAll(reader)
+	if string(body) != "item list" {
+		t.Errorf("Expected GET body, got: %q", body)
+	}
+}
+
+func TestHeadStreamResponseOfUnknownLength(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		if n, err := w.Write([]byte("discarded")); n != 9 || err != nil {
+			t.Errorf("Expected HEAD write to be discarded silently, got %d %v", n, err)
+		}
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.Write([]byte("HEAD / HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"HEAD / HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"))
+
+	reader := bufio.NewReader(conn)
+	_, headers := readResponseHead(t, reader)
+	if headers[TransferEncodingHeader] != "chunked" {
+		t.Errorf("Expected HEAD to report chunked framing, got: %v", headers)
+	}
+
+	statusLine, headers := readResponseHead(t, reader)
+	if statusLine != "HTTP/1.0 200 OK" || headers[ConnectionHeader] != KeepAliveHeader {
+		t.Errorf("Expected HTTP/1.0 HEAD to keep the connection, got: %s %v", statusLine, headers)
+	}
+	if _, ok := headers[TransferEncodingHeader]; ok {
+		t.Errorf("Expected no transfer-encoding for HTTP/1.0, got: %v", headers)
+	}
+}
+
+func TestBodylessStatuses(t *testing.T) {
+	for _, code := range []int{204, 304} {
+		t.Run(strconv.Itoa(code), func(t *testing.T) {
+			handler := func(req *HTTPRequest) *HTTPResponse {
+				return &HTTPResponse{
+					StatusCode: code,
+					StatusText: http.StatusText(code),
+					Headers:    NewHeader("etag", `"v1"`, TrailerHeader, "x-checksum"),
+					Body:       strings.NewReader("must not be sent"),
+				}
+			}
+
+			_, addr, cleanup := setupTestServer(t, handler)
+			defer cleanup()
+
+			conn := makeRawConnection(t, addr)
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+			conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+				"GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+			reader := bufio.NewReader(conn)
+			for i := 0; i < 2; i++ {
+				statusLine, headers := readResponseHead(t, reader)
+				if !strings.HasPrefix(statusLine, "HTTP/1.1 "+strconv.Itoa(code)) {
+					t.Fatalf("Expected %d, got: %s", code, statusLine)
+				}
+				for _, name := range []string{ContentLengthHeader, TransferEncodingHeader, TrailerHeader} {
+					if _, ok := headers[name]; ok {
+						t.Errorf("Expected no %s header on %d, got: %v", name, code, headers)
+					}
+				}
+				if headers["etag"] != `"v1"` {
+					t.Errorf("Expected other headers to be kept, got: %v", headers)
+				}
+			}
+
+			if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+				t.Errorf("Expected no body, got: %q", rest)
+			}
+		})
+	}
+}
+
+func TestWriteAfterNoContent(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.WriteHeader(http.StatusNoContent)
+		if _, err := w.Write([]byte("body")); err != ErrBodyNotAllowed {
+			t.Errorf("Expected ErrBodyNotAllowed, got: %v", err)
+		}
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasPrefix(response, "HTTP/1.1 204 No Content\r\n") || !strings.HasSuffix(response, "\r\n\r\n") {
+		t.Errorf("Expected bare 204 response, got: %q", response)
+	}
+}
+
+func TestInterimResponse(t *testing.T) {
+	handler := func(w ResponseWriter, req *HTTPRequest) {
+		w.Header().Set("link", "</style.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+
+		w.Header().Set(ContentLengthHeader, "2")
+		w.Write([]byte("ok"))
+	}
+
+	_, addr, cleanup := setupStreamTestServer(t, handler)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+	if !strings.HasPrefix(response, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\nHTTP/1.1 200 OK\r\n") {
+		t.Errorf("Expected 103 before the final response, got: %q", response)
+	}
+	if !strings.HasSuffix(response, "\r\n\r\nok") {
+		t.Errorf("Expected final body, got: %q", response)
+	}
+
+	response = makeRequest(t, addr, "GET / HTTP/1.0\r\n\r\n")
+	if !strings.HasPrefix(response, "HTTP/1.0 200 OK\r\n") {
+		t.Errorf("Expected no interim response for HTTP/1.0, got: %q", response)
+	}
+}
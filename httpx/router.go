@@ -0,0 +1,359 @@
+package httpx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	staticSegment segmentKind = iota
+	paramSegment
+	wildcardSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	pattern  string
+	segments []segment
+	handlers map[string]HandlerFunc
+	limits   map[string]int64
+}
+
+// Route is a single method and pattern registration.
+type Route struct {
+	route  *route
+	method string
+}
+
+// MaxRequestSize overrides the server's MaxRequestSize for this route. It
+// takes effect when the server's RequestSizeLimit is set to the router's
+// RequestSizeLimit.
+func (r *Route) MaxRequestSize(limit int64) *Route {
+	r.route.limits[r.method] = limit
+	return r
+}
+
+// Router dispatches requests by method and path pattern. Patterns are made
+// of static segments, named parameters (/users/:id) and a trailing wildcard
+// (/static/*filepath) that captures the rest of the path.
+type Router struct {
+	*RouteGroup
+
+	routes []*route
+
+	NotFound HandlerFunc
+}
+
+// RouteGroup registers routes under a shared path prefix.
+type RouteGroup struct {
+	router *Router
+	prefix string
+}
+
+func NewRouter() *Router {
+	r := &Router{}
+	r.RouteGroup = &RouteGroup{router: r}
+	return r
+}
+
+func (g *RouteGroup) Group(prefix string) *RouteGroup {
+	return &RouteGroup{
+		router: g.router,
+		prefix: joinPattern(g.prefix, prefix),
+	}
+}
+
+func (g *RouteGroup) Handle(method, pattern string, handler HandlerFunc) *Route {
+	method = strings.ToUpper(method)
+	rt := g.router.addRoute(method, joinPattern(g.prefix, pattern), handler)
+	return &Route{route: rt, method: method}
+}
+
+func (g *RouteGroup) Get(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodGet, pattern, handler)
+}
+
+func (g *RouteGroup) Post(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodPost, pattern, handler)
+}
+
+func (g *RouteGroup) Put(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodPut, pattern, handler)
+}
+
+func (g *RouteGroup) Patch(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodPatch, pattern, handler)
+}
+
+func (g *RouteGroup) Delete(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodDelete, pattern, handler)
+}
+
+func (g *RouteGroup) Options(pattern string, handler HandlerFunc) *Route {
+	return g.Handle(http.MethodOptions, pattern, handler)
+}
+
+func joinPattern(prefix, pattern string) string {
+	if prefix == "" {
+		return pattern
+	}
+	if pattern == "" || pattern == "/" {
+		return strings.TrimSuffix(prefix, "/")
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(pattern, "/")
+}
+
+func (r *Router) addRoute(method, pattern string, handler HandlerFunc) *route {
+	if handler == nil {
+		panic(fmt.Sprintf("httpx: nil handler for %s %s", method, pattern))
+	}
+
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("httpx: invalid pattern %q: %v", pattern, err))
+	}
+
+	for _, existing := range r.routes {
+		if !sameShape(existing.segments, segments) {
+			continue
+		}
+		if existing.pattern != pattern {
+			panic(fmt.Sprintf("httpx: pattern %q conflicts with %q", pattern, existing.pattern))
+		}
+		if _, exists := existing.handlers[method]; exists {
+			panic(fmt.Sprintf("httpx: duplicate route %s %s", method, pattern))
+		}
+		existing.handlers[method] = handler
+		return existing
+	}
+
+	rt := &route{
+		pattern:  pattern,
+		segments: segments,
+		handlers: map[string]HandlerFunc{method: handler},
+		limits:   make(map[string]int64),
+	}
+	r.routes = append(r.routes, rt)
+
+	return rt
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with /")
+	}
+
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+
+	for i, part := range parts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			if len(part) == 1 {
+				return nil, fmt.Errorf("unnamed parameter")
+			}
+			segments = append(segments, segment{kind: paramSegment, value: part[1:]})
+		case strings.HasPrefix(part, "*"):
+			if i != len(parts)-1 {
+				return nil, fmt.Errorf("wildcard must be the last segment")
+			}
+			if len(part) == 1 {
+				return nil, fmt.Errorf("unnamed wildcard")
+			}
+			segments = append(segments, segment{kind: wildcardSegment, value: part[1:]})
+		default:
+			segments = append(segments, segment{kind: staticSegment, value: part})
+		}
+	}
+
+	return segments, nil
+}
+
+// sameShape reports whether two patterns match exactly the same paths.
+func sameShape(a, b []segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].kind != b[i].kind {
+			return false
+		}
+		if a[i].kind == staticSegment && a[i].value != b[i].value {
+			return false
+		}
+	}
+	return true
+}
+
+func (rt *route) match(parts []string) (map[string]string, bool) {
+	var params map[string]string
+
+	for i, seg := range rt.segments {
+		if seg.kind == wildcardSegment {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[seg.value] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case staticSegment:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case paramSegment:
+			if parts[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[seg.value] = parts[i]
+		}
+	}
+
+	return params, len(parts) == len(rt.segments)
+}
+
+// moreSpecific reports whether a should win over b when both match a path.
+// Static segments beat parameters, which beat wildcards.
+func moreSpecific(a, b *route) bool {
+	for i := 0; i < len(a.segments) && i < len(b.segments); i++ {
+		if a.segments[i].kind != b.segments[i].kind {
+			return a.segments[i].kind < b.segments[i].kind
+		}
+	}
+	return len(a.segments) > len(b.segments)
+}
+
+func (r *Router) lookup(path string) (*route, map[string]string) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	var best *route
+	var bestParams map[string]string
+
+	for _, rt := range r.routes {
+		params, ok := rt.match(parts)
+		if !ok {
+			continue
+		}
+		if best == nil || moreSpecific(rt, best) {
+			best = rt
+			bestParams = params
+		}
+	}
+
+	return best, bestParams
+}
+
+func (rt *route) allowed() string {
+	methods := make([]string, 0, len(rt.handlers)+2)
+	for method := range rt.handlers {
+		methods = append(methods, method)
+	}
+	if _, exists := rt.handlers[http.MethodGet]; exists {
+		if _, exists := rt.handlers[http.MethodHead]; !exists {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	if _, exists := rt.handlers[http.MethodOptions]; !exists {
+		methods = append(methods, http.MethodOptions)
+	}
+
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// RequestSizeLimit returns the MaxRequestSize override of the route matching
+// req, or 0 if it has none. Assign it to HTTPServer.RequestSizeLimit.
+func (r *Router) RequestSizeLimit(req *HTTPRequest) int64 {
+	rt, _ := r.lookup(req.Path)
+	if rt == nil {
+		return 0
+	}
+	return rt.limits[req.Method]
+}
+
+// StripPrefix serves requests below prefix with handler, as if their path
+// didn't start with it. A request for prefix itself is redirected to prefix
+// with a trailing slash, so relative links resolve below it. Other paths get
+// 404.
+func StripPrefix(prefix string, handler HandlerFunc) HandlerFunc {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return func(req *HTTPRequest) *HTTPResponse {
+		rest, ok := strings.CutPrefix(req.Path, prefix)
+		if !ok || rest != "" && rest[0] != '/' {
+			return textResponse(http.StatusNotFound, "Not Found")
+		}
+		if rest == "" {
+			location := &url.URL{Path: path.Base(prefix) + "/", RawQuery: req.RawQuery}
+			return redirectResponse(location.String())
+		}
+
+		// the request is shared with the server, which still reads its
+		// trailer and cleanup state, so only the path is swapped
+		original := req.Path
+		req.Path = rest
+		defer func() { req.Path = original }()
+
+		return handler(req)
+	}
+}
+
+// ServeRequest is a HandlerFunc that dispatches req to the matching route.
+func (r *Router) ServeRequest(req *HTTPRequest) *HTTPResponse {
+	if req.Path == "*" {
+		return &HTTPResponse{
+			StatusCode: http.StatusNoContent,
+			StatusText: http.StatusText(http.StatusNoContent),
+		}
+	}
+
+	rt, params := r.lookup(req.Path)
+	if rt == nil {
+		if r.NotFound != nil {
+			return r.NotFound(req)
+		}
+		return textResponse(http.StatusNotFound, "Not Found")
+	}
+
+	req.Params = params
+
+	if handler, exists := rt.handlers[req.Method]; exists {
+		return handler(req)
+	}
+
+	if req.Method == http.MethodHead {
+		if handler, exists := rt.handlers[http.MethodGet]; exists {
+			return handler(req)
+		}
+	}
+
+	if req.Method == http.MethodOptions {
+		return &HTTPResponse{
+			StatusCode: http.StatusNoContent,
+			StatusText: http.StatusText(http.StatusNoContent),
+			Headers:    NewHeader(AllowHeader, rt.allowed()),
+		}
+	}
+
+	res := textResponse(http.StatusMethodNotAllowed, "Method Not Allowed")
+	res.Headers.Set(AllowHeader, rt.allowed())
+	return res
+}
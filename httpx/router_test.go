@@ -0,0 +1,186 @@
+package httpx
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func routeTo(name string) HandlerFunc {
+	return func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       strings.NewReader(name),
+		}
+	}
+}
+
+func serveRoute(t *testing.T, router *Router, method, path string) (*HTTPResponse, *HTTPRequest, string) {
+	req := &HTTPRequest{Method: method, Version: HTTP11Version}
+	if err := req.parseTarget(path); err != nil {
+		t.Fatalf("Invalid test path %q: %v", path, err)
+	}
+
+	res := router.ServeRequest(req)
+	if res == nil {
+		t.Fatalf("Router returned nil response for %s %s", method, path)
+	}
+
+	body := ""
+	if res.Body != nil {
+		data, _ := io.ReadAll(res.Body)
+		body = string(data)
+	}
+
+	return res, req, body
+}
+
+func TestRouterMatching(t *testing.T) {
+	router := NewRouter()
+	router.Get("/", routeTo("root"))
+	router.Get("/users", routeTo("users"))
+	router.Get("/users/me", routeTo("me"))
+	router.Get("/users/:id", routeTo("user"))
+	router.Get("/users/:id/posts/:post", routeTo("post"))
+	router.Get("/static/*filepath", routeTo("static"))
+
+	tests := []struct {
+		path   string
+		body   string
+		params map[string]string
+	}{
+		{"/", "root", nil},
+		{"/users", "users", nil},
+		{"/users/me", "me", nil},
+		{"/users/42", "user", map[string]string{"id": "42"}},
+		{"/users/42/posts/7", "post", map[string]string{"id": "42", "post": "7"}},
+		{"/static/css/site.css", "static", map[string]string{"filepath": "css/site.css"}},
+		{"/users/42?tab=posts", "user", map[string]string{"id": "42"}},
+	}
+
+	for _, tt := range tests {
+		res, req, body := serveRoute(t, router, "GET", tt.path)
+		if res.StatusCode != 200 || body != tt.body {
+			t.Errorf("GET %s: expected %q, got %d %q", tt.path, tt.body, res.StatusCode, body)
+			continue
+		}
+		for name, value := range tt.params {
+			if req.Param(name) != value {
+				t.Errorf("GET %s: expected param %s=%q, got %q", tt.path, name, value, req.Param(name))
+			}
+		}
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	router := NewRouter()
+	router.Get("/users/:id", routeTo("user"))
+
+	for _, path := range []string{"/missing", "/users", "/users/1/extra"} {
+		res, _, _ := serveRoute(t, router, "GET", path)
+		if res.StatusCode != 404 {
+			t.Errorf("GET %s: expected 404, got %d", path, res.StatusCode)
+		}
+	}
+
+	router.NotFound = func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{StatusCode: 404, StatusText: "Not Found", Body: strings.NewReader("custom")}
+	}
+
+	if _, _, body := serveRoute(t, router, "GET", "/missing"); body != "custom" {
+		t.Errorf("Expected custom not found handler, got %q", body)
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter()
+	router.Get("/items", routeTo("list"))
+	router.Post("/items", routeTo("create"))
+
+	res, _, _ := serveRoute(t, router, "DELETE", "/items")
+	if res.StatusCode != 405 {
+		t.Fatalf("Expected 405, got %d", res.StatusCode)
+	}
+	if allow := res.Headers.Get(AllowHeader); allow != "GET, HEAD, OPTIONS, POST" {
+		t.Errorf("Expected Allow header listing methods, got %q", allow)
+	}
+}
+
+func TestRouterHeadAndOptions(t *testing.T) {
+	router := NewRouter()
+	router.Get("/items", routeTo("list"))
+
+	if res, _, body := serveRoute(t, router, "HEAD", "/items"); res.StatusCode != 200 || body != "list" {
+		t.Errorf("Expected HEAD to use GET handler, got %d %q", res.StatusCode, body)
+	}
+
+	res, _, _ := serveRoute(t, router, "OPTIONS", "/items")
+	if res.StatusCode != 204 {
+		t.Errorf("Expected 204 for OPTIONS, got %d", res.StatusCode)
+	}
+	if allow := res.Headers.Get(AllowHeader); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("Expected Allow header for OPTIONS, got %q", allow)
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	router := NewRouter()
+	api := router.Group("/api")
+	v1 := api.Group("/v1/")
+	v1.Get("/", routeTo("v1 index"))
+	v1.Get("/users/:id", routeTo("v1 user"))
+
+	if _, _, body := serveRoute(t, router, "GET", "/api/v1"); body != "v1 index" {
+		t.Errorf("Expected group index route, got %q", body)
+	}
+
+	_, req, body := serveRoute(t, router, "GET", "/api/v1/users/9")
+	if body != "v1 user" || req.Param("id") != "9" {
+		t.Errorf("Expected grouped param route, got %q with id=%q", body, req.Param("id"))
+	}
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	patterns := []string{"users", "/files/*path/more", "/users/:", "/*"}
+
+	for _, pattern := range patterns {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic for pattern %q", pattern)
+				}
+			}()
+			NewRouter().Get(pattern, routeTo("x"))
+		}()
+	}
+
+	router := NewRouter()
+	router.Get("/users/:id", routeTo("x"))
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected panic for conflicting parameter names")
+		}
+	}()
+	router.Get("/users/:name", routeTo("y"))
+}
+
+func TestRouterWithServer(t *testing.T) {
+	router := NewRouter()
+	router.Get("/hello/:name", func(req *HTTPRequest) *HTTPResponse {
+		return &HTTPResponse{
+			StatusCode: 200,
+			StatusText: "OK",
+			Body:       strings.NewReader("hello " + req.Param("name")),
+		}
+	})
+
+	_, addr, cleanup := setupTestServer(t, router.ServeRequest)
+	defer cleanup()
+
+	response := makeRequest(t, addr, "GET /hello/gopher HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if !strings.Contains(response, "hello gopher") {
+		t.Errorf("Expected routed response, got: %s", response)
+	}
+}
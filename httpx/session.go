@@ -0,0 +1,520 @@
+package httpx
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SessionData is what a SessionStore keeps for one session.
+type SessionData struct {
+	Values  map[string]string
+	Flashes []string
+	Expires time.Time
+}
+
+// SessionStore keeps session data on the server, so the cookie only holds
+// the session ID. Implementations must be safe for concurrent use.
+type SessionStore interface {
+	// Load returns nil, without an error, for unknown or expired sessions.
+	Load(id string) (*SessionData, error)
+	Save(id string, data *SessionData) error
+	Delete(id string) error
+}
+
+type SessionConfig struct {
+	// Keys encrypt and authenticate the session cookie and must be at least
+	// 32 random bytes each. The first key seals new cookies, the others are
+	// only used to open existing ones: rotate by prepending a new key and
+	// dropping the old one once MaxAge has passed.
+	Keys [][]byte
+
+	// Store keeps session data on the server. Nil keeps it in the cookie,
+	// which limits it to about 3KB.
+	Store SessionStore
+
+	// CookieName defaults to DefaultSessionCookieName.
+	CookieName string
+
+	// MaxAge is how long a session lasts after its last change. Defaults to
+	// DefaultSessionMaxAge.
+	MaxAge time.Duration
+
+	Path     string // defaults to "/"
+	Domain   string
+	Secure   bool
+	SameSite SameSite // defaults to SameSiteLax
+}
+
+// SessionManager loads and saves the session of each request. Its
+// Middleware makes the session available to handlers through req.Session().
+type SessionManager struct {
+	cfg   SessionConfig
+	aeads []cipher.AEAD
+}
+
+// NewSessionManager checks the keys of cfg and fills in its defaults.
+func NewSessionManager(cfg SessionConfig) (*SessionManager, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("httpx: sessions need at least one key")
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = DefaultSessionCookieName
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = DefaultSessionMaxAge
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	if cfg.SameSite == SameSiteDefault {
+		cfg.SameSite = SameSiteLax
+	}
+
+	m := &SessionManager{cfg: cfg}
+	for i, key := range cfg.Keys {
+		if len(key) < sessionKeySize {
+			return nil, fmt.Errorf("httpx: session key %d has %d bytes, at least %d are needed", i, len(key), sessionKeySize)
+		}
+
+		// keys of any length map to an AES-256 key
+		derived := sha256.Sum256(append([]byte("httpx session key:"), key...))
+		block, err := aes.NewCipher(derived[:])
+		if err != nil {
+			return nil, fmt.Errorf("httpx: error creating session cipher: %v", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("httpx: error creating session cipher: %v", err)
+		}
+		m.aeads = append(m.aeads, aead)
+	}
+
+	return m, nil
+}
+
+// Middleware loads the session on the first call to req.Session() and saves
+// it, setting the cookie, when the response header is written. With a
+// Store, changes made after that are still saved; without one they are lost.
+func (m *SessionManager) Middleware() Middleware {
+	return func(next StreamHandlerFunc) StreamHandlerFunc {
+		return func(w ResponseWriter, req *HTTPRequest) {
+			session := &Session{manager: m, req: req}
+			req.session = session
+
+			sw := &sessionWriter{ResponseWriter: w, session: session}
+			next(sw, req)
+
+			// a handler that wrote nothing still gets its header written
+			// after this, and with it the cookie
+			if err := session.save(w.Header(), sw.wroteHeader); err != nil {
+				fmt.Printf("Error saving session: %v\n", err)
+			}
+		}
+	}
+}
+
+// Session returns the session of the request, or nil when no
+// SessionManager middleware handles it.
+func (r *HTTPRequest) Session() *Session {
+	if r.session == nil {
+		return nil
+	}
+	r.session.load()
+	return r.session
+}
+
+// Session holds the values of one client's session. It isn't safe for
+// concurrent use.
+type Session struct {
+	manager *SessionManager
+	req     *HTTPRequest
+
+	loaded    bool
+	hadCookie bool
+	cookieID  string
+	id        string
+	oldID     string
+	data      SessionData
+	modified  bool
+	destroyed bool
+}
+
+// sessionPayload is sealed into the cookie. Values and flashes are left out
+// when a store keeps them.
+type sessionPayload struct {
+	ID      string            `json:"i"`
+	Values  map[string]string `json:"v,omitempty"`
+	Flashes []string          `json:"f,omitempty"`
+	Expires int64             `json:"e"`
+}
+
+func (s *Session) load() {
+	if s.loaded {
+		return
+	}
+	s.loaded = true
+
+	if cookie, err := s.req.Cookie(s.manager.cfg.CookieName); err == nil {
+		s.hadCookie = true
+		if err := s.open(cookie.Value); err != nil {
+			fmt.Printf("Discarding session: %v\n", err)
+			s.reset()
+		}
+	}
+
+	if s.id == "" {
+		s.reset()
+	}
+}
+
+func (s *Session) open(value string) error {
+	var payload sessionPayload
+	if err := s.manager.unseal(value, &payload); err != nil {
+		return err
+	}
+	if payload.ID == "" || time.Now().Unix() >= payload.Expires {
+		return errors.New("expired session cookie")
+	}
+
+	s.id = payload.ID
+	s.cookieID = payload.ID
+	s.data = SessionData{Values: payload.Values, Flashes: payload.Flashes, Expires: time.Unix(payload.Expires, 0)}
+
+	if store := s.manager.cfg.Store; store != nil {
+		data, err := store.Load(payload.ID)
+		if err != nil {
+			return fmt.Errorf("error loading session: %v", err)
+		}
+		if data == nil {
+			return errors.New("unknown session")
+		}
+		s.data = *data
+	}
+
+	if s.data.Values == nil {
+		s.data.Values = map[string]string{}
+	}
+	return nil
+}
+
+// reset starts an empty session under a new ID.
+func (s *Session) reset() {
+	s.id = newSessionID()
+	s.data = SessionData{Values: map[string]string{}}
+}
+
+// ID identifies the session; it changes with Regenerate.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew reports whether the client had no valid session before this request.
+func (s *Session) IsNew() bool {
+	return s.data.Expires.IsZero()
+}
+
+func (s *Session) Get(key string) string {
+	return s.data.Values[key]
+}
+
+func (s *Session) Set(key, value string) {
+	s.data.Values[key] = value
+	s.modified = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.modified = true
+	}
+}
+
+// Regenerate moves the session to a new ID, keeping its values. Call it
+// when privileges change, such as on login. With a Store the old ID is
+// deleted, so one an attacker planted or learned before becomes useless.
+// Without a Store nothing on the server records the old ID: a copy of the
+// previous cookie still opens its session until that cookie expires.
+func (s *Session) Regenerate() {
+	if s.oldID == "" && !s.IsNew() {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.modified = true
+}
+
+// Destroy removes the session and tells the client to delete its cookie,
+// such as on logout. The session starts over empty if it is used again in
+// the same request. Without a Store only the client forgets it: a copy of
+// the cookie stays valid until it expires, so keep MaxAge short or use a
+// Store when sessions must be revoked.
+func (s *Session) Destroy() {
+	if s.oldID == "" && !s.IsNew() {
+		s.oldID = s.id
+	}
+	s.reset()
+	s.destroyed = true
+	s.modified = false
+}
+
+// AddFlash stores a message for the next request that reads Flashes, such
+// as a notice shown after a redirect.
+func (s *Session) AddFlash(message string) {
+	s.data.Flashes = append(s.data.Flashes, message)
+	s.modified = true
+}
+
+// Flashes returns the pending flash messages and removes them.
+func (s *Session) Flashes() []string {
+	flashes := s.data.Flashes
+	if len(flashes) > 0 {
+		s.data.Flashes = nil
+		s.modified = true
+	}
+	return flashes
+}
+
+// save stores a changed session and adds its cookie to header, unless the
+// header has already been sent.
+func (s *Session) save(header *Header, headerSent bool) error {
+	if !s.loaded || !s.modified && !s.destroyed {
+		return nil
+	}
+
+	cfg := &s.manager.cfg
+
+	// with a store the client can keep its cookie, unless the ID changed
+	if headerSent && !(cfg.Store != nil && s.modified && !s.destroyed && s.id == s.cookieID) {
+		return errors.New("session changed after the response header was sent")
+	}
+
+	if s.oldID != "" && cfg.Store != nil {
+		if err := cfg.Store.Delete(s.oldID); err != nil {
+			return fmt.Errorf("error deleting session: %v", err)
+		}
+	}
+
+	cookie := &Cookie{
+		Name:     cfg.CookieName,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	}
+
+	if !s.modified {
+		s.destroyed = false
+		if !s.hadCookie {
+			return nil
+		}
+		cookie.MaxAge = -1
+		SetCookie(header, cookie)
+		return nil
+	}
+
+	s.data.Expires = time.Now().Add(cfg.MaxAge)
+	payload := sessionPayload{ID: s.id, Expires: s.data.Expires.Unix()}
+
+	if cfg.Store != nil {
+		data := s.data
+		data.Values = maps.Clone(s.data.Values)
+		data.Flashes = slices.Clone(s.data.Flashes)
+		if err := cfg.Store.Save(s.id, &data); err != nil {
+			return fmt.Errorf("error saving session: %v", err)
+		}
+	} else {
+		payload.Values = s.data.Values
+		payload.Flashes = s.data.Flashes
+	}
+
+	s.modified = false
+	s.destroyed = false
+	s.oldID = ""
+
+	if headerSent {
+		return nil
+	}
+
+	value, err := s.manager.seal(payload)
+	if err != nil {
+		return err
+	}
+	if len(cfg.CookieName)+len(value) > maxCookieSize {
+		return fmt.Errorf("session cookie of %d bytes is too large, use a SessionStore", len(value))
+	}
+
+	cookie.Value = value
+	cookie.MaxAge = int(cfg.MaxAge / time.Second)
+	SetCookie(header, cookie)
+	s.hadCookie = true
+	s.cookieID = s.id
+	return nil
+}
+
+// seal encrypts and authenticates v with the first key. The cookie name is
+// bound in as additional data, so a value can't be moved to another cookie.
+func (m *SessionManager) seal(v any) (string, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("error encoding session: %v", err)
+	}
+
+	aead := m.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(m.cfg.CookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// unseal opens value with any of the keys.
+func (m *SessionManager) unseal(value string, v any) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return errors.New("malformed session cookie")
+	}
+
+	for _, aead := range m.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(m.cfg.CookieName))
+		if err != nil {
+			continue
+		}
+
+		if err := json.Unmarshal(plaintext, v); err != nil {
+			return errors.New("malformed session cookie")
+		}
+		return nil
+	}
+
+	return errors.New("session cookie failed authentication")
+}
+
+func newSessionID() string {
+	var buf [32]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(fmt.Sprintf("httpx: can't generate session ID: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+// sessionWriter saves the session just before the header is written, while
+// the cookie can still be added.
+type sessionWriter struct {
+	ResponseWriter
+	session     *Session
+	wroteHeader bool
+}
+
+func (sw *sessionWriter) WriteHeader(statusCode int) {
+	if !sw.wroteHeader && !isInterimStatus(statusCode) {
+		if err := sw.session.save(sw.Header(), false); err != nil {
+			fmt.Printf("Error saving session: %v\n", err)
+		}
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *sessionWriter) Write(p []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *sessionWriter) Flush() error {
+	if !sw.wroteHeader {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Flush()
+}
+
+// MemoryStore is a SessionStore in process memory. Sessions are lost on
+// restart and not shared between processes.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]*SessionData
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]*SessionData{}, lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Load(id string) (*SessionData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if !time.Now().Before(data.Expires) {
+		delete(s.sessions, id)
+		return nil, nil
+	}
+
+	return &SessionData{
+		Values:  maps.Clone(data.Values),
+		Flashes: slices.Clone(data.Flashes),
+		Expires: data.Expires,
+	}, nil
+}
+
+func (s *MemoryStore) Save(id string, data *SessionData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) >= sessionSweepInterval {
+		s.lastSweep = now
+		for id, data := range s.sessions {
+			if !now.Before(data.Expires) {
+				delete(s.sessions, id)
+			}
+		}
+	}
+
+	s.sessions[id] = &SessionData{
+		Values:  maps.Clone(data.Values),
+		Flashes: slices.Clone(data.Flashes),
+		Expires: data.Expires,
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions held, including expired ones that
+// haven't been swept yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
+}
@@ -0,0 +1,257 @@
+package httpx
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSessionKey  = bytes.Repeat([]byte("k"), 32)
+	otherSessionKey = bytes.Repeat([]byte("o"), 32)
+)
+
+func setupSessionServer(t *testing.T, cfg SessionConfig) (string, func()) {
+	manager, err := NewSessionManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create session manager: %v", err)
+	}
+
+	router := NewRouter()
+	router.Get("/set", func(req *HTTPRequest) *HTTPResponse {
+		for key, values := range req.Query() {
+			req.Session().Set(key, values[0])
+		}
+		return textResponse(200, "set")
+	})
+	router.Get("/get", func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, req.Session().Get(req.Query().Get("key")))
+	})
+	router.Get("/id", func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, req.Session().ID())
+	})
+	router.Get("/login", func(req *HTTPRequest) *HTTPResponse {
+		req.Session().Regenerate()
+		req.Session().Set("user", "alice")
+		return textResponse(200, req.Session().ID())
+	})
+	router.Get("/logout", func(req *HTTPRequest) *HTTPResponse {
+		req.Session().Destroy()
+		return textResponse(200, "bye")
+	})
+	router.Get("/flash", func(req *HTTPRequest) *HTTPResponse {
+		req.Session().AddFlash(req.Query().Get("message"))
+		return textResponse(200, "added")
+	})
+	router.Get("/flashes", func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, strings.Join(req.Session().Flashes(), ","))
+	})
+	router.Get("/static", func(req *HTTPRequest) *HTTPResponse {
+		return textResponse(200, "no session")
+	})
+
+	_, addr, cleanup := setupStreamTestServer(t, Chain(HandlerFunc(router.ServeRequest).Stream(), manager.Middleware()))
+	return addr, cleanup
+}
+
+// sessionGet requests target with the given session cookie and returns the
+// body and the new cookie, if one was set.
+func sessionGet(t *testing.T, addr, target, cookie string) (string, *http.Cookie) {
+	conn := makeRawConnection(t, addr)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	request := "GET " + target + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n"
+	if cookie != "" {
+		request += "Cookie: session=" + cookie + "\r\n"
+	}
+	conn.Write([]byte(request + "\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "GET"})
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+
+	for _, c := range res.Cookies() {
+		if c.Name == "session" {
+			return string(body), c
+		}
+	}
+	return string(body), nil
+}
+
+func TestNewSessionManagerKeys(t *testing.T) {
+	if _, err := NewSessionManager(SessionConfig{}); err == nil {
+		t.Error("Expected an error without keys")
+	}
+	if _, err := NewSessionManager(SessionConfig{Keys: [][]byte{[]byte("short")}}); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
+
+func TestCookieSession(t *testing.T) {
+	addr, cleanup := setupSessionServer(t, SessionConfig{Keys: [][]byte{testSessionKey}, MaxAge: time.Hour})
+	defer cleanup()
+
+	if _, cookie := sessionGet(t, addr, "/static", ""); cookie != nil {
+		t.Errorf("Expected no cookie for a request not using the session, got %v", cookie)
+	}
+	if _, cookie := sessionGet(t, addr, "/get?key=user", ""); cookie != nil {
+		t.Errorf("Expected no cookie for an unchanged session, got %v", cookie)
+	}
+
+	_, cookie := sessionGet(t, addr, "/set?user=alice", "")
+	if cookie == nil {
+		t.Fatal("Expected a session cookie")
+	}
+	if !cookie.HttpOnly || cookie.Path != "/" || cookie.MaxAge != 3600 || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Unexpected cookie attributes %v", cookie)
+	}
+	if strings.Contains(cookie.Value, "alice") {
+		t.Error("Expected the cookie to be encrypted")
+	}
+
+	if body, _ := sessionGet(t, addr, "/get?key=user", cookie.Value); body != "alice" {
+		t.Errorf("Expected alice, got %q", body)
+	}
+
+	// a modified cookie fails authentication
+	tampered := []byte(cookie.Value)
+	tampered[len(tampered)/2] ^= 1
+	if body, _ := sessionGet(t, addr, "/get?key=user", string(tampered)); body != "" {
+		t.Errorf("Expected tampered cookie to be rejected, got %q", body)
+	}
+
+	_, deleted := sessionGet(t, addr, "/logout", cookie.Value)
+	if deleted == nil || deleted.MaxAge >= 0 {
+		t.Errorf("Expected the cookie to be deleted, got %v", deleted)
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	old, _ := NewSessionManager(SessionConfig{Keys: [][]byte{otherSessionKey}})
+	rotated, _ := NewSessionManager(SessionConfig{Keys: [][]byte{testSessionKey, otherSessionKey}})
+	dropped, _ := NewSessionManager(SessionConfig{Keys: [][]byte{testSessionKey}})
+	renamed, _ := NewSessionManager(SessionConfig{Keys: [][]byte{otherSessionKey}, CookieName: "other"})
+
+	value, err := old.seal(sessionPayload{ID: "id"})
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	var payload sessionPayload
+	if err := rotated.unseal(value, &payload); err != nil || payload.ID != "id" {
+		t.Errorf("Expected old key to still open the cookie, got %v", err)
+	}
+	if err := dropped.unseal(value, &payload); err == nil {
+		t.Error("Expected dropped key to be rejected")
+	}
+	if err := renamed.unseal(value, &payload); err == nil {
+		t.Error("Expected the value to be bound to the cookie name")
+	}
+
+	// new cookies are sealed with the first key
+	value, _ = rotated.seal(sessionPayload{ID: "new"})
+	if err := dropped.unseal(value, &payload); err != nil || payload.ID != "new" {
+		t.Errorf("Expected new cookie to use the first key, got %v", err)
+	}
+}
+
+func TestCookieSessionCannotRevoke(t *testing.T) {
+	addr, cleanup := setupSessionServer(t, SessionConfig{Keys: [][]byte{testSessionKey}})
+	defer cleanup()
+
+	_, before := sessionGet(t, addr, "/set?theme=dark", "")
+	oldID, _ := sessionGet(t, addr, "/id", before.Value)
+
+	newID, after := sessionGet(t, addr, "/login", before.Value)
+	if after == nil || newID == oldID {
+		t.Fatalf("Expected a new session ID, got %q", newID)
+	}
+
+	// without a store nothing marks the earlier cookie as replaced
+	if id, _ := sessionGet(t, addr, "/id", before.Value); id != oldID {
+		t.Errorf("Expected the old cookie to still open its session, got ID %q", id)
+	}
+
+	_, deleted := sessionGet(t, addr, "/logout", after.Value)
+	if deleted == nil || deleted.MaxAge >= 0 {
+		t.Fatalf("Expected the cookie to be deleted, got %v", deleted)
+	}
+	if body, _ := sessionGet(t, addr, "/get?key=user", after.Value); body != "alice" {
+		t.Errorf("Expected a copy of the destroyed cookie to stay valid, got %q", body)
+	}
+}
+
+func TestStoreSessionRegenerate(t *testing.T) {
+	store := NewMemoryStore()
+	addr, cleanup := setupSessionServer(t, SessionConfig{Keys: [][]byte{testSessionKey}, Store: store})
+	defer cleanup()
+
+	_, cookie := sessionGet(t, addr, "/set?theme=dark", "")
+	if cookie == nil || store.Len() != 1 {
+		t.Fatalf("Expected a stored session, got cookie %v and %d sessions", cookie, store.Len())
+	}
+	oldID, _ := sessionGet(t, addr, "/id", cookie.Value)
+
+	newID, newCookie := sessionGet(t, addr, "/login", cookie.Value)
+	if newCookie == nil || newID == oldID {
+		t.Fatalf("Expected a new session ID, got %q", newID)
+	}
+	if store.Len() != 1 {
+		t.Errorf("Expected the old session to be deleted, got %d sessions", store.Len())
+	}
+	if body, _ := sessionGet(t, addr, "/get?key=theme", newCookie.Value); body != "dark" {
+		t.Errorf("Expected values to survive regeneration, got %q", body)
+	}
+	if body, _ := sessionGet(t, addr, "/get?key=user", cookie.Value); body != "" {
+		t.Errorf("Expected old cookie to be useless, got %q", body)
+	}
+
+	sessionGet(t, addr, "/logout", newCookie.Value)
+	if store.Len() != 0 {
+		t.Errorf("Expected destroyed session to be deleted, got %d sessions", store.Len())
+	}
+}
+
+func TestSessionFlashes(t *testing.T) {
+	addr, cleanup := setupSessionServer(t, SessionConfig{Keys: [][]byte{testSessionKey}})
+	defer cleanup()
+
+	_, cookie := sessionGet(t, addr, "/flash?message=saved", "")
+	_, cookie = sessionGet(t, addr, "/flash?message=again", cookie.Value)
+
+	body, next := sessionGet(t, addr, "/flashes", cookie.Value)
+	if body != "saved,again" || next == nil {
+		t.Fatalf("Expected both flashes and an updated cookie, got %q", body)
+	}
+	if body, _ := sessionGet(t, addr, "/flashes", next.Value); body != "" {
+		t.Errorf("Expected flashes to be read once, got %q", body)
+	}
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save("live", &SessionData{Values: map[string]string{"a": "1"}, Expires: time.Now().Add(time.Hour)})
+	store.Save("dead", &SessionData{Expires: time.Now().Add(-time.Second)})
+
+	if data, _ := store.Load("dead"); data != nil {
+		t.Errorf("Expected expired session to be gone, got %v", data)
+	}
+
+	data, _ := store.Load("live")
+	if data == nil || data.Values["a"] != "1" {
+		t.Fatalf("Expected live session, got %v", data)
+	}
+
+	// loaded data is a copy
+	data.Values["a"] = "2"
+	if data, _ := store.Load("live"); data.Values["a"] != "1" {
+		t.Errorf("Expected stored values to be unaffected, got %q", data.Values["a"])
+	}
+}